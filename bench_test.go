@@ -0,0 +1,124 @@
+package weasel_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ztcollazo/weasel"
+)
+
+var benchSchema = `
+DROP TABLE IF EXISTS bench;
+
+CREATE TABLE bench (
+	id serial primary key,
+	name text,
+	email text,
+	age integer,
+	score double precision,
+	active boolean
+);
+
+INSERT INTO bench (name, email, age, score, active)
+	SELECT 'name ' || i, 'user' || i || '@example.com', i % 90, i / 10.0, i % 2 = 0
+	FROM generate_series(1, 1000) AS i;`
+
+type BenchSchema struct {
+	weasel.Document[*BenchSchema]
+	Id     int     `db:"id" pk:"" type:"serial"`
+	Name   string  `db:"name" type:"text"`
+	Email  string  `db:"email" type:"text"`
+	Age    int     `db:"age" type:"integer"`
+	Score  float64 `db:"score" type:"double precision"`
+	Active bool    `db:"active" type:"boolean"`
+}
+
+var Bench = weasel.Create(conn, &BenchSchema{}, "bench")
+
+func setupBench(b *testing.B) *BenchSchema {
+	b.Helper()
+	conn.DB.MustExec(benchSchema)
+	d, err := Bench.Find(1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	return d
+}
+
+func BenchmarkFind(b *testing.B) {
+	setupBench(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := Bench.Find(1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindPrepared(b *testing.B) {
+	conn.PrepareStatements(100)
+	b.Cleanup(func() { conn.PrepareStatements(0) })
+	setupBench(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := Bench.Find(1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAll(b *testing.B) {
+	setupBench(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := Bench.All().Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	setupBench(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := Bench.Create(&BenchSchema{Name: "bench", Email: fmt.Sprint("bench", i, "@example.com")}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	d := setupBench(b)
+	for i := 0; i < b.N; i++ {
+		d.Age = i
+		if err := d.Save(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	d := setupBench(b)
+	for i := 0; i < b.N; i++ {
+		d.Get("email")
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	d := setupBench(b)
+	for i := 0; i < b.N; i++ {
+		d.Set("age", i)
+	}
+}
+
+func BenchmarkToMap(b *testing.B) {
+	d := setupBench(b)
+	for i := 0; i < b.N; i++ {
+		d.ToMap()
+	}
+}
+
+func BenchmarkDirtyFields(b *testing.B) {
+	d := setupBench(b)
+	for i := 0; i < b.N; i++ {
+		d.DirtyFields()
+	}
+}
@@ -0,0 +1,321 @@
+package weasel
+
+import (
+	"container/list"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// CacheStore stores the query results of the models that use Model.Cache. Implement it to keep
+// them in another backend, such as Redis or memcached; LRUCache is the in-memory implementation.
+// Values are CachedResults.
+type CacheStore interface {
+	// Get returns the value stored under key, if it has not expired.
+	Get(key string) (any, bool)
+	// Set stores the value under key for ttl, or until it is evicted if ttl is 0.
+	Set(key string, value any, ttl time.Duration)
+	// DeletePrefix removes the values whose key starts with prefix.
+	DeletePrefix(prefix string)
+}
+
+// CachedResult is what the result cache stores for a query: the columns of its result, and the
+// values of its rows in the same order. Stores that serialize values need to encode it.
+type CachedResult struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// resultCache holds the result cache settings of a model.
+type resultCache struct {
+	ttl   time.Duration
+	store CacheStore
+}
+
+// Cache caches the results of the model's Find, FindBy and All queries (including those of its
+// groups and relation lookups) in store for ttl, so that repeated reads do not hit the database.
+// A nil store is an LRUCache of 1000 results. Entries are keyed by SQL and arguments, and all
+// of the model's entries are invalidated by writes to its table through weasel: Save, Delete,
+// Create, UpdateAll, DeleteAll, and the counter caches and dependent actions of other models.
+// Writes in a transaction invalidate the entries once it is committed. Queries in transactions,
+// locking reads, and reads from a replica shortly after a write (see SetStickyWindow) are not
+// cached; use NoCache to bypass the cache for other queries. It suits read-heavy reference
+// tables, for example:
+//
+//	Place.Cache(10*time.Minute, nil)
+//	Place.Find(1)           // Queries the database
+//	Place.Find(1)           // From the cache
+//	Place.NoCache().Find(1) // Queries the database
+func (m *Model[Doc]) Cache(ttl time.Duration, store CacheStore) {
+	if store == nil {
+		store = NewLRUCache(1000)
+	}
+	m.cache.Store(&resultCache{ttl: ttl, store: store})
+}
+
+// NoCache returns a copy of the group whose queries bypass the result cache. See Model.Cache.
+func (m Group[Doc]) NoCache() *Group[Doc] {
+	m.noCache = true
+	return &m
+}
+
+// invalidate removes all of the model's entries from the result cache.
+func (m *Model[Doc]) invalidate() {
+	if c := m.cache.Load(); c != nil {
+		c.store.DeletePrefix(m.tableName + ":")
+	}
+}
+
+// invalidate removes the result cache entries of the model registered for table, if any, once
+// the connection's transaction is committed (see onCommit).
+func (c Connection) invalidate(table string) {
+	if c.registry == nil {
+		return
+	}
+	if m, ok := c.registry.lookup(table); ok {
+		c.onCommit(m.invalidate)
+	}
+}
+
+// cacheKey returns the key of a query in the result cache, or an empty string if the query
+// should not be cached.
+func (m *Model[Doc]) cacheKey(conn Connection, noCache bool, l lock, kind, query string, args []any) string {
+	if m.cache.Load() == nil || noCache || conn.tx != nil || l.locking() || conn.lagging() {
+		return ""
+	}
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = keyValue(arg)
+	}
+	return fmt.Sprintf("%s:%s:%s:%#v", m.tableName, kind, query, values)
+}
+
+// keyValue returns the value an argument is keyed by in the result cache: what it points to, or
+// the value it passes to the driver, so that equal arguments share a key whatever their address.
+func keyValue(arg any) any {
+	if v, ok := arg.(driver.Valuer); ok {
+		if value, err := v.Value(); err == nil {
+			return value
+		}
+	}
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// cached returns the cached result under key, if there is one, with the indexes of the fields of
+// its columns.
+func (m *Model[Doc]) cached(key string) (CachedResult, [][]int, bool) {
+	if key == "" {
+		return CachedResult{}, nil, false
+	}
+	c := m.cache.Load()
+	if c == nil {
+		return CachedResult{}, nil, false
+	}
+	v, ok := c.store.Get(key)
+	if !ok {
+		return CachedResult{}, nil, false
+	}
+	r, ok := v.(CachedResult)
+	if !ok {
+		return CachedResult{}, nil, false
+	}
+	indexes, err := m.layout.traversals(r.Columns)
+	if err != nil {
+		return CachedResult{}, nil, false
+	}
+	return r, indexes, true
+}
+
+// restore sets the values of a row of the cached result on the document, like a scan would. The
+// values are copied, so that changing the slices, maps and pointers of a document does not change
+// the cached result.
+func (m *Model[Doc]) restore(doc Doc, r CachedResult, indexes [][]int, row int) {
+	v := reflect.ValueOf(doc).Elem()
+	for i, index := range indexes {
+		f := reflectx.FieldByIndexes(v, index)
+		f.Set(convert(copied(r.Rows[row][i]), f.Type()))
+	}
+	any(doc).(loader).loaded(m.layout.unloaded(r.Columns))
+}
+
+// store stores copies of the values of the columns of the documents under key.
+func (m *Model[Doc]) store(key string, columns []string, docs []Doc) {
+	c := m.cache.Load()
+	if key == "" || c == nil {
+		return
+	}
+	indexes, err := m.layout.traversals(columns)
+	if err != nil {
+		return
+	}
+	r := CachedResult{Columns: columns, Rows: make([][]any, 0, len(docs))}
+	for _, doc := range docs {
+		v := reflect.ValueOf(doc).Elem()
+		row := make([]any, len(indexes))
+		for i, index := range indexes {
+			row[i] = copied(v.FieldByIndex(index).Interface())
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	c.store.Set(key, r, c.ttl)
+}
+
+// copied returns a deep copy of a value of the result cache: its slices, maps and pointers, and
+// those of its exported struct fields, are copied. Unexported fields, such as the location of a
+// time.Time, are shared.
+func copied(value any) any {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return value
+	}
+	return copyValue(v).Interface()
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	}
+	return v
+}
+
+// LRUCache is an in-memory CacheStore that holds up to a number of values, evicting the least
+// recently used ones. It is safe for concurrent use.
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	lru   *list.List // Of *lruEntry, the most recently used first
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	expires time.Time // Zero if the value does not expire
+}
+
+// NewLRUCache creates an LRUCache that holds up to size values.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{size: size, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get implements CacheStore.
+func (c *LRUCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.value, true
+}
+
+// Set implements CacheStore.
+func (c *LRUCache) Set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// DeletePrefix implements CacheStore.
+func (c *LRUCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+}
+
+// Len returns the number of values in the cache, including those that expired but were not
+// evicted yet.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
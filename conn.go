@@ -1,8 +1,11 @@
 package weasel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"sync"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
@@ -14,9 +17,25 @@ import (
 // not officially supported.
 // The query builder type comes from Squirrel, and the DB type is *sqlx.DB.
 type Connection struct {
-	Builder sq.StatementBuilderType
-	DB      *sqlx.DB
-	driver  string
+	Builder    sq.StatementBuilderType
+	DB         *sqlx.DB
+	driver     string
+	tx         *sqlx.Tx
+	txState    *txState
+	registry   *Registry
+	ctx        context.Context
+	tenant     any
+	hasTenant  bool
+	allTenants bool
+	schema     func(id any) string
+	replicas   *replicas
+	primary    bool
+	hooks      *hooks
+	stmts      *stmtCache
+	model      string
+	op         Operation
+	caller     context.Context // The context of the caller of the current operation; see operation
+	operating  bool
 }
 
 // Opts represents a generalized connection options structure for the Connect function.
@@ -172,23 +191,172 @@ func (o *Opts) setDefaults(driver string) {
 
 // Connect creates a connection to the database. The opts string as the second parameter
 // is a wrapper of sqlx.Connect but uses a custom Opts struct that it parses into a DSN.
-func Connect(driver string, options Opts) Connection {
+// Options for read replicas can follow; the reads of groups and queries (including relation
+// lookups) are then spread over the healthy replicas, round-robin, while writes, transactions
+// and locking reads go to the primary. See UsePrimary and SetStickyWindow. For example:
+//
+//	conn := weasel.Connect("postgres", primary, replica1, replica2)
+func Connect(driver string, options Opts, replicas ...Opts) Connection {
 	options.setDefaults(driver)
 	dsn, err := options.toDSN(driver)
 	if err != nil {
 		panic(err)
 	}
 
-	db := sqlx.MustConnect(driver, dsn)
-	builder := sq.StatementBuilder.RunWith(db)
+	conn := Connection{
+		DB:       sqlx.MustConnect(driver, dsn),
+		driver:   driver,
+		registry: NewRegistry(),
+		replicas: connectReplicas(driver, replicas),
+		hooks:    &hooks{driver: driver},
+		stmts:    &stmtCache{},
+	}
+	conn.Builder = sq.StatementBuilder
 
 	if driver == "postgres" {
-		builder = builder.PlaceholderFormat(sq.Dollar)
+		conn.Builder = conn.Builder.PlaceholderFormat(sq.Dollar)
+	}
+	conn.rewire()
+
+	return conn
+}
+
+// Registry returns the registry of the models created on the connection.
+func (c Connection) Registry() *Registry {
+	return c.registry
+}
+
+// Close closes the connection's database and its read replicas. It applies to every copy of the
+// connection, which cannot be used after.
+func (c Connection) Close() error {
+	errs := []error{c.DB.Close()}
+	if c.replicas != nil {
+		for _, rep := range c.replicas.dbs {
+			errs = append(errs, rep.db.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Transaction runs fn in a database transaction. The connection passed to fn runs its queries
+// (including those of its Builder) in the transaction, which is committed if fn returns nil and
+// rolled back otherwise. Calling Transaction on a connection that is already in a transaction
+// runs fn in that same transaction.
+func (c Connection) Transaction(fn func(tx Connection) error) (err error) {
+	if c.tx != nil {
+		return fn(c)
+	}
+	tx, err := c.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	state := &txState{}
+	defer state.end()
+	txc := c
+	txc.tx = tx
+	txc.txState = state
+	txc.rewire()
+	if err = fn(txc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	c.wrote()
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for _, fn := range state.end() {
+		fn()
+	}
+	return nil
+}
+
+// savepoint runs fn in a savepoint of the connection's transaction, and rolls back to it if fn fails,
+// so that the transaction can go on after a failed statement (postgres aborts the whole transaction
+// otherwise). Outside of transactions, it just runs fn.
+func (c Connection) savepoint(fn func() error) error {
+	if c.tx == nil {
+		return fn()
+	}
+	exec := func(stmt string) error {
+		return c.observe(QueryEvent{Operation: OpExec, SQL: stmt}, func(ctx context.Context) (int64, error) {
+			_, err := c.tx.ExecContext(ctx, stmt)
+			return 0, err
+		})
+	}
+	if err := exec("SAVEPOINT weasel"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rerr := exec("ROLLBACK TO SAVEPOINT weasel"); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
 	}
+	return exec("RELEASE SAVEPOINT weasel")
+}
+
+// txState is shared by the copies of a transaction's connection, for example the ones that
+// documents loaded in the transaction are bound to.
+type txState struct {
+	mu      sync.Mutex
+	done    bool
+	commits []func() // Run once the transaction is committed; see onCommit
+}
+
+// end marks the transaction as committed or rolled back, and returns what to run if it was
+// committed.
+func (s *txState) end() []func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	commits := s.commits
+	s.commits = nil
+	return commits
+}
+
+// onCommit runs fn once the connection's transaction is committed, or right away outside of
+// transactions. The result cache is invalidated with it, so that reads outside of the transaction
+// cannot cache the rows it changes again before they are committed.
+func (c Connection) onCommit(fn func()) {
+	if c.tx != nil && c.txState != nil {
+		c.txState.mu.Lock()
+		if !c.txState.done {
+			c.txState.commits = append(c.txState.commits, fn)
+			c.txState.mu.Unlock()
+			return
+		}
+		c.txState.mu.Unlock()
+	}
+	fn()
+}
+
+// ended reports whether the transaction is over.
+func (s *txState) ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// current returns the connection, without its transaction if the transaction is over.
+func (c Connection) current() Connection {
+	if c.tx != nil && c.txState != nil && c.txState.ended() {
+		c.tx = nil
+		c.txState = nil
+		c.rewire()
+	}
+	return c
+}
 
-	return Connection{
-		DB:      db,
-		Builder: builder,
-		driver:  driver,
+// ext returns what queries should run on: the transaction if there is one, or the database.
+func (c Connection) ext() sqlx.ExtContext {
+	if c.tx != nil {
+		return c.tx
 	}
+	return c.DB
 }
@@ -0,0 +1,145 @@
+package weasel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// counters returns the model's BelongsTo relations that have a counter cache, sorted by field name.
+func (m *Model[Doc]) counters() []Relation {
+	rels := make([]Relation, 0)
+	for _, rel := range m.relations {
+		if rel.Variant == "belongsTo" && rel.CounterCache != "" {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Slice(rels, func(i, j int) bool { return rels[i].Name < rels[j].Name })
+	return rels
+}
+
+func (m *Model[Doc]) hasCounters() bool {
+	return len(m.counters()) > 0
+}
+
+// count adds delta to the counter cache column of the parent with the given key.
+func (m *Model[Doc]) count(conn Connection, rel Relation, doc DocumentBase, key any, delta int) error {
+	if key == nil || reflect.ValueOf(key).IsZero() {
+		return nil
+	}
+	table := rel.Table
+	if rel.Polymorphic != "" {
+		table = fmt.Sprint(doc.Get(rel.TypeKey()))
+	}
+	_, err := conn.Builder.Update(conn.Table(table)).
+		Set(rel.CounterCache, sq.Expr(rel.CounterCache+" + ?", delta)).
+		Where(Eq{rel.ForeignKey: key}).
+		Exec()
+	conn.invalidate(table)
+	return err
+}
+
+// countAll adds delta to the counter caches of all of the document's parents.
+func (m *Model[Doc]) countAll(conn Connection, doc DocumentBase, delta int) error {
+	for _, rel := range m.counters() {
+		if err := m.count(conn, rel, doc, doc.Get(rel.Key), delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recount moves the document's counts from its old parents to its new ones, for the keys
+// that changed since it was loaded.
+func (m *Model[Doc]) recount(conn Connection, doc DocumentBase, original map[string]any) error {
+	if original == nil {
+		return nil
+	}
+	for _, rel := range m.counters() {
+		old, now := original[rel.Key], doc.Get(rel.Key)
+		if reflect.DeepEqual(old, now) {
+			continue
+		}
+		if err := m.count(conn, rel, doc, old, -1); err != nil {
+			return err
+		}
+		if err := m.count(conn, rel, doc, now, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetCounters recomputes the counter cache columns of the model's BelongsTo relations from the
+// actual number of documents. Pass field names to reset only those relations. It runs on the
+// group's connection, so that it can be part of a transaction, for example:
+//
+//	Person.ResetCounters("Place") // UPDATE place SET people_count = (SELECT COUNT(*) ...)
+//	conn.Transaction(func(tx weasel.Connection) error {
+//		return Person.Using(tx).ResetCounters()
+//	})
+//
+// For multi-tenant models, it only counts and updates the rows of the connection's tenant, and
+// returns ErrNoTenant if the connection has none; use AllTenants to reset every tenant's counters.
+func (m Group[Doc]) ResetCounters(relations ...string) error {
+	conn := m.connection()
+	tenant, err := m.Model.tenantWhere(conn)
+	if err != nil {
+		return err
+	}
+	for _, rel := range m.Model.counters() {
+		if len(relations) > 0 && !contains(relations, rel.Name) {
+			continue
+		}
+		tables := []string{rel.Table}
+		if rel.Polymorphic != "" {
+			tables = []string{}
+			query, args := conn.Builder.Select("DISTINCT " + rel.TypeKey()).From(conn.Table(m.Model.tableName)).Where(NotEq{rel.TypeKey(): nil}).Where(tenant).MustSql()
+			event := QueryEvent{Model: m.Model.tableName, Operation: OpExec, SQL: query, Args: args}
+			err := conn.observe(event, func(ctx context.Context) (int64, error) {
+				err := sqlx.SelectContext(ctx, conn.ext(), &tables, query, args...)
+				return int64(len(tables)), err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, table := range tables {
+			count := sq.Select("COUNT(*)").From(conn.Table(m.Model.tableName)).Where(m.Model.tableName + "." + rel.Key + " = " + table + "." + rel.ForeignKey).Where(tenant)
+			if rel.Polymorphic != "" {
+				count = count.Where(Eq{m.Model.tableName + "." + rel.TypeKey(): table})
+			}
+			query, args, err := count.ToSql()
+			if err != nil {
+				return err
+			}
+			update := conn.Builder.Update(conn.Table(table)).Set(rel.CounterCache, sq.Expr("("+query+")", args...))
+			if parent, ok := conn.registry.lookup(table); ok {
+				where, err := parent.tenantWhere(conn)
+				if err != nil {
+					return err
+				}
+				update = update.Where(where)
+			}
+			_, err = update.Exec()
+			conn.invalidate(table)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func contains[T comparable](s []T, v T) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package weasel
+
+import (
+	"fmt"
+	"sort"
+)
+
+// deleter is implemented by every document through the embedded Document.
+type deleter interface {
+	deleteWith(conn Connection) error
+}
+
+// deleteWith runs the dependent actions of the document's relations, then deletes it, on conn.
+func (d Document[Doc]) deleteWith(conn Connection) error {
+	names := make([]string, 0, len(d.Model.relations))
+	for name, rel := range d.Model.relations {
+		if rel.Dependent != "" && rel.Variant != "belongsTo" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := d.Model.dependent(conn, d.Model.relations[name], d.doc); err != nil {
+			return err
+		}
+	}
+	tenant, err := d.Model.tenantWhere(conn)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Instrument(d.Model.tableName, OpDelete).Builder.Delete(conn.Table(d.Model.tableName)).Where(Eq{d.Model.pk: d.Get(d.Model.pk)}).Where(tenant).Exec()
+	conn.onCommit(d.Model.invalidate)
+	if err != nil {
+		return err
+	}
+	untrack(conn, d.Model, d.doc)
+	return d.Model.countAll(conn, d.doc, -1)
+}
+
+// checkDependent panics if the relation's dependent tag is not one of the actions below, so that
+// a typo is reported when the model is created rather than on the first Delete.
+func checkDependent(table string, rel Relation) {
+	switch rel.Dependent {
+	case "", "restrict", "delete", "destroy", "nullify":
+	default:
+		panic(fmt.Errorf("unknown dependent action %q on %s.%s", rel.Dependent, table, rel.Name))
+	}
+}
+
+// dependent runs the relation's dependent action for owner:
+//   - restrict returns a *RestrictError if there are related documents.
+//   - delete deletes the related rows with one statement.
+//   - destroy loads the related documents and deletes each of them with Delete,
+//     so that their own dependent actions run.
+//   - nullify sets the related documents' foreign key to NULL.
+//
+// For through relations, the actions apply to the join table rows; delete, destroy and
+// nullify all remove them.
+func (m *Model[Doc]) dependent(conn Connection, rel Relation, owner Doc) error {
+	table := rel.Table
+	var where Eq
+	if rel.Through != "" {
+		table = rel.Through
+		where = Eq{rel.Through + "." + rel.Key: owner.Get(m.pk)}
+	} else {
+		where = Eq{rel.Table + "." + rel.ForeignKey: owner.Get(rel.Key)}
+		if rel.Polymorphic != "" {
+			where[rel.Table+"."+rel.TypeKey()] = m.tableName
+		}
+	}
+
+	switch {
+	case rel.Dependent == "restrict":
+		var cnt int
+		if err := conn.Builder.Select("COUNT(1)").From(conn.Table(table)).Where(where).Scan(&cnt); err != nil {
+			return err
+		}
+		if cnt > 0 {
+			return &RestrictError{Table: m.tableName, Relation: rel.Name, Count: cnt}
+		}
+		return nil
+	case rel.Dependent == "delete" || rel.Through != "":
+		_, err := conn.Builder.Delete(conn.Table(table)).Where(where).Exec()
+		conn.invalidate(rel.Table)
+		return err
+	case rel.Dependent == "destroy":
+		target, ok := conn.registry.lookup(rel.Table)
+		if !ok {
+			return fmt.Errorf("no model registered for table %q", rel.Table)
+		}
+		return target.destroyWhere(conn, where)
+	case rel.Dependent == "nullify":
+		q := conn.Builder.Update(conn.Table(table)).Set(rel.ForeignKey, nil).Where(where)
+		if rel.Polymorphic != "" {
+			q = q.Set(rel.TypeKey(), nil)
+		}
+		_, err := q.Exec()
+		conn.invalidate(rel.Table)
+		return err
+	default:
+		return fmt.Errorf("unknown dependent action %q on %s.%s", rel.Dependent, m.tableName, rel.Name)
+	}
+}
+
+// destroyWhere loads the documents matching where and deletes each of them on conn.
+func (m *Model[Doc]) destroyWhere(conn Connection, where any) error {
+	docs, err := selectMany(conn, m.columns(nil), m).Where(where).Exec()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := any(doc).(deleter).deleteWith(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasDependents checks if any of the model's relations has a dependent action.
+func (m *Model[Doc]) hasDependents() bool {
+	for _, rel := range m.relations {
+		if rel.Dependent != "" && rel.Variant != "belongsTo" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,16 @@
 package weasel
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"reflect"
+	"slices"
+	"sort"
 
 	"github.com/carlmjohnson/truthy"
+	"github.com/jmoiron/sqlx"
 )
 
 // DocumentBase provides an interface to be used for times when you may not know the schema
@@ -14,6 +19,7 @@ import (
 type DocumentBase interface {
 	Delete() error
 	Save() error
+	Reload() error
 	ToJSON() (string, error)
 	ToMap() map[string]any
 	Get(string) any
@@ -27,7 +33,11 @@ type DocumentBase interface {
 	Init()
 	IsValid() bool
 	IsInvalid() bool
+	IsDirty() bool
+	DirtyFields() []string
+	IsLoaded(string) bool
 	Table() string
+	TenantKey() string
 	Conn() Connection
 	Use(Middleware)
 }
@@ -47,11 +57,17 @@ type document[Doc DocumentBase] interface {
 //	}
 type Document[Doc document[Doc]] struct {
 	document[Doc]
-	Model  *Model[Doc]
-	Errors []error
-	get    func(string) any
-	set    func(string, any)
-	use    func(Middleware)
+	Model    *Model[Doc]
+	Errors   []error
+	doc      Doc
+	original map[string]any
+	unloaded map[string]bool
+	assigned map[string]bool
+	attached map[string][]DocumentBase
+	conn     *Connection
+	get      func(string) any
+	set      func(string, any)
+	use      func(Middleware)
 }
 
 // Middleware is a type that all middleware (passed to the Use function) should be/return.
@@ -63,9 +79,11 @@ type Middleware func(DocumentBase)
 func (d *Document[Doc]) Create(doc Doc, model *Model[Doc]) {
 	d.Errors = []error{}
 	d.Model = model
+	d.doc = doc
 	d.get = get(doc)
 	d.set = set(doc)
 	d.use = use(doc)
+	model.wire(doc)
 }
 
 // ToJSON returns a JSON string of all of the document's fields.
@@ -134,40 +152,177 @@ func (d Document[Doc]) Table() string {
 	return d.Model.tableName
 }
 
-// Conn returns the current connection. See the connection docs.
+// Conn returns the connection the document was loaded or created on, or the model's connection.
+// Once the transaction a document was loaded in is over, its queries run outside of it.
+// See the connection docs.
 func (d Document[Doc]) Conn() Connection {
+	if d.conn != nil {
+		return d.conn.current()
+	}
 	return d.Model.Conn
 }
 
+// SetContext makes the document's queries (Save, Delete, Reload, its relations and validations)
+// carry ctx, for example so that their spans are children of the caller's. Documents carry the
+// context of the group that loaded them until then. See Connection.WithContext.
+func (d *Document[Doc]) SetContext(ctx context.Context) {
+	d.bind(d.Conn().WithContext(ctx))
+}
+
 // Get returns a property (DB or struct) on the document. You may need to use type assertion.
 func (d Document[Doc]) Get(name string) any {
 	return d.get(name)
 }
 
-// Set sets a property on the document (DB or struct).
-func (d Document[Doc]) Set(name string, value any) {
+// Set sets a property on the document (DB or struct). Setting a field skipped by a partial load
+// marks it as loaded, even to its zero value, so that Save writes it.
+func (d *Document[Doc]) Set(name string, value any) {
 	d.set(name, value)
+	if d.unloaded[name] {
+		if d.assigned == nil {
+			d.assigned = make(map[string]bool)
+		}
+		d.assigned[name] = true
+	}
 }
 
-// Delete completely removes the document from the database.
-func (d Document[Doc]) Delete() error {
-	_, err := d.Model.Conn.Builder.Delete(d.Model.tableName).Where(Eq{d.Model.pk: d.Get(d.Model.pk)}).Exec()
-	return err
+// Delete completely removes the document from the database. If any of its HasMany or HasOne
+// relations has a `dependent` tag, the dependent actions run first, in the same transaction:
+//   - destroy deletes the related documents one by one, running their own dependent actions.
+//   - delete deletes the related rows with a single statement.
+//   - nullify sets the related documents' foreign key to NULL.
+//   - restrict returns a *RestrictError if there are related documents.
+func (d Document[Doc]) Delete() (err error) {
+	conn, done := d.operation("Delete")
+	defer func() { done(err) }()
+	if !d.Model.hasDependents() && !d.Model.hasCounters() {
+		return d.deleteWith(conn)
+	}
+	return conn.Transaction(func(tx Connection) error {
+		return d.deleteWith(tx)
+	})
 }
 
-// Save saves the document's changes, changed either by Set or manually.
-func (d Document[Doc]) Save() error {
+// Save saves the document's changes, changed either by Set or manually. Only the fields that
+// changed since the document was loaded are written, so that columns maintained by the database
+// (such as counter caches) and the fields skipped by a partial load are not overwritten. To write
+// the zero value to a field skipped by a partial load, use Set (see IsLoaded). Documents attached
+// with Attach are persisted too, in the same transaction.
+func (d Document[Doc]) Save() (err error) {
+	conn, done := d.operation("Save")
+	defer func() { done(err) }()
 	callInit(&d)
 	if len(d.Errors) > 0 {
 		return errors.New("document is invalid")
 	}
+	if len(d.attached) == 0 && !d.Model.hasCounters() {
+		return d.saveWith(conn)
+	}
+	return conn.Transaction(func(tx Connection) error {
+		return d.saveWith(tx)
+	})
+}
+
+// operation starts the operation name (see OperationHook) for the document. Until the returned
+// function is called with its error, the document's own queries, such as those of its validations
+// and relations, run in it too.
+func (d Document[Doc]) operation(name string) (Connection, func(err error)) {
+	conn, done := d.Conn().operation(OperationEvent{Model: d.Model.tableName, Name: name})
+	if !conn.operating || !truthy.Value(d.doc) {
+		return conn, done
+	}
+	bind(d.doc, conn)
+	return conn, func(err error) { finish(done, err, d.doc) }
+}
+
+func (d Document[Doc]) saveWith(conn Connection) error {
+	attached := d.attached
+	if err := d.Model.saveParents(conn, d.doc); err != nil {
+		return err
+	}
+	if dirty := d.DirtyFields(); len(dirty) > 0 {
+		sort.Strings(dirty)
+		tenant, err := d.Model.tenantWhere(conn)
+		if err != nil {
+			return err
+		}
+		q := conn.Instrument(d.Model.tableName, OpSave).Builder.Update(conn.Table(d.Model.tableName)).Where(Eq{d.Model.pk: d.Get(d.Model.pk)}).Where(tenant)
+		for _, k := range dirty {
+			q = q.Set(k, d.Get(k))
+		}
+		_, err = q.Exec()
+		conn.onCommit(d.Model.invalidate)
+		if err != nil {
+			return err
+		}
+		if err := d.Model.recount(conn, d.doc, d.original); err != nil {
+			return err
+		}
+	}
+	if err := d.Model.saveChildren(conn, d.doc, attached); err != nil {
+		return err
+	}
+	any(d.doc).(persistence).detach()
+	markClean(d.doc)
+	return nil
+}
 
-	q := d.Model.Conn.Builder.Update(d.Model.tableName).Where(Eq{d.Model.pk: d.Get(d.Model.pk)})
-	for k := range d.Model.fields {
-		q = q.Set(k, d.Get(k))
+// Reload reads the document again from the database by its primary key, into the same struct.
+// Unsaved changes are discarded, and Init runs again so that middleware (relations, validations)
+// is rebound to the fresh values. It returns ErrNotFound if the row no longer exists.
+func (d Document[Doc]) Reload() error {
+	conn := d.Conn()
+	tenant, err := d.Model.tenantWhere(conn)
+	if err != nil {
+		return err
+	}
+	query, args := conn.Builder.Select(d.Model.columns(nil)...).From(conn.Table(d.Model.tableName)).Where(Eq{d.Model.pk: d.Get(d.Model.pk)}).Where(tenant).MustSql()
+	event := QueryEvent{Model: d.Model.tableName, Operation: OpFind, SQL: query, Args: args}
+	err = conn.read(event, false, func(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+		_, err := d.Model.layout.get(ctx, db, d.doc, query, args...)
+		return 1, err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
 	}
-	_, err := q.Exec()
-	return err
+	markClean(d.doc)
+	callInit(d.doc, d.Model)
+	if len(d.doc.AllErrors()) > 0 {
+		return errors.New("document is invalid")
+	}
+	return nil
+}
+
+// IsDirty checks if any of the document's fields changed since it was loaded or saved.
+func (d Document[Doc]) IsDirty() bool {
+	return len(d.DirtyFields()) > 0
+}
+
+// DirtyFields returns the column names of the fields that changed since the document was
+// loaded or saved. A document that was never loaded reports all of its fields.
+func (d Document[Doc]) DirtyFields() []string {
+	dirty := make([]string, 0)
+	for f := range d.Model.fields {
+		if v, ok := d.original[f]; !ok || d.assigned[f] || !reflect.DeepEqual(v, d.Get(f)) {
+			dirty = append(dirty, f)
+		}
+	}
+	return dirty
+}
+
+// IsLoaded checks if the field (a column name) was loaded from the database, or set since.
+// Fields skipped by a partial load (see Group.Select and Group.Omit) hold their zero value and
+// are not loaded until they are set; Save does not write them, and validations skip them.
+// Assigning the zero value directly to such a field cannot be told apart from not setting it,
+// so use Set for that.
+func (d Document[Doc]) IsLoaded(field string) bool {
+	if !d.unloaded[field] || d.assigned[field] {
+		return true
+	}
+	v, ok := d.original[field]
+	return ok && !reflect.DeepEqual(v, d.Get(field))
 }
 
 // IsValid checks that the document does not contain any errors.
@@ -183,9 +338,10 @@ func (d Document[Doc]) IsInvalid() bool {
 
 func get[Doc document[Doc]](d Doc) func(string) any {
 	v := reflect.Indirect(reflect.ValueOf(d))
+	l := d.GetModel().layout
 	return func(name string) any {
-		if truthy.Value(d.GetModel().fields[name]) {
-			return v.FieldByName(d.GetModel().fields[name].Name).Interface()
+		if index, ok := l.field(name); ok {
+			return v.FieldByIndex(index).Interface()
 		} else if v.IsValid() && v.CanInterface() {
 			return v.FieldByName(name).Interface()
 		} else {
@@ -195,15 +351,72 @@ func get[Doc document[Doc]](d Doc) func(string) any {
 }
 
 func set[Doc document[Doc]](d Doc) func(string, any) {
-	v := reflect.Indirect(reflect.ValueOf(&d)).Elem()
+	v := reflect.ValueOf(d).Elem()
+	l := d.GetModel().layout
 	return func(name string, value any) {
-		n := reflect.ValueOf(value)
-		field := d.GetModel().fields[name]
-		if truthy.Value(field) {
-			v.FieldByName(field.Name).Set(n)
+		var f reflect.Value
+		if index, ok := l.field(name); ok {
+			f = v.FieldByIndex(index)
 		} else {
-			v.FieldByName(name).Set(n)
+			f = v.FieldByName(name)
 		}
+		f.Set(convert(value, f.Type()))
+	}
+}
+
+// convert returns value as a reflect.Value of type t. Nil becomes the zero value, and values
+// of the same kind or between numeric kinds are converted, so that for example an int from a
+// where clause can be set on an int64 field.
+func convert(value any, t reflect.Type) reflect.Value {
+	n := reflect.ValueOf(value)
+	if !n.IsValid() {
+		return reflect.Zero(t)
+	}
+	if !n.Type().AssignableTo(t) && n.Type().ConvertibleTo(t) && (n.Kind() == t.Kind() || numeric(n.Kind()) && numeric(t.Kind())) {
+		return n.Convert(t)
+	}
+	return n
+}
+
+func numeric(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// cleaner is implemented by every document through the embedded Document.
+type cleaner interface {
+	clean()
+}
+
+func (d *Document[Doc]) clean() {
+	if d.original != nil && len(d.unloaded) > 0 {
+		// The fields skipped by a partial load that were saved are loaded now. The set of unloaded
+		// fields is shared by the documents of a result, so it is copied.
+		written := d.DirtyFields()
+		unloaded := make(map[string]bool, len(d.unloaded))
+		for f := range d.unloaded {
+			if !slices.Contains(written, f) {
+				unloaded[f] = true
+			}
+		}
+		d.unloaded = unloaded
+	}
+	d.original = d.ToMap()
+	d.assigned = nil
+}
+
+// loader is implemented by every document through the embedded Document.
+type loader interface {
+	loaded(unloaded map[string]bool)
+}
+
+func (d *Document[Doc]) loaded(unloaded map[string]bool) {
+	d.unloaded = unloaded
+}
+
+// markClean records the document's current values as its persisted state.
+func markClean(doc any) {
+	if c, ok := doc.(cleaner); ok {
+		c.clean()
 	}
 }
 
@@ -214,18 +427,11 @@ func use[Doc document[Doc]](doc Doc) func(Middleware) {
 }
 
 func callInit[Doc DocumentBase](d Doc, model ...*Model[Doc]) {
-	v := reflect.ValueOf(d)
 	if truthy.Value(model) && truthy.Value(model[0]) {
-		anonymous := make([]reflect.Value, 0)
-		x := v.Elem()
-		for i := 0; i < x.NumField(); i++ {
-			if f := x.Type().Field(i); f.Anonymous {
-				anonymous = append(anonymous, x.Field(i).Addr())
-			}
-		}
-		for _, a := range anonymous {
-			if m := a.MethodByName("Create"); m.IsValid() {
-				m.Call([]reflect.Value{v, reflect.ValueOf(model[0])})
+		x := reflect.ValueOf(d).Elem()
+		for _, index := range model[0].layout.creators {
+			if c, ok := x.FieldByIndex(index).Addr().Interface().(creator[Doc]); ok {
+				c.Create(d, model[0])
 			}
 		}
 	}
@@ -0,0 +1,57 @@
+package weasel
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNotFound is returned when a document that is expected to exist is not in the database,
+// for example when reloading a deleted document.
+var ErrNotFound = errors.New("document not found")
+
+// ErrNotThrough is returned when association methods such as Add and Remove are used on a
+// group that is not a has many through relation.
+var ErrNotThrough = errors.New("group is not a has many through relation")
+
+// ErrLockUnsupported is returned when a locking clause (ForUpdate, ForShare, NoWait, SkipLocked)
+// is used with a driver that has no row-level locking, such as sqlite.
+var ErrLockUnsupported = errors.New("row locking is not supported by this driver")
+
+// ErrNoTenant is returned by queries of a multi-tenant model (one with a field tagged `tenant`)
+// on a connection without a tenant. See Connection.ForTenant and Connection.AllTenants.
+var ErrNoTenant = errors.New("no tenant set for a multi-tenant model")
+
+// RestrictError is returned by Delete when a relation tagged with dependent:"restrict"
+// still has related documents.
+type RestrictError struct {
+	Table    string // The table of the document being deleted
+	Relation string // The field name of the restricting relation
+	Count    int    // The number of related documents
+}
+
+func (e *RestrictError) Error() string {
+	return fmt.Sprintf("cannot delete from %s: relation %s has %d dependent documents", e.Table, e.Relation, e.Count)
+}
+
+// isUniqueViolation checks if err comes from a unique constraint, by the driver's error code:
+// SQLSTATE 23505 for PostgreSQL (lib/pq, pgx), error 1062 for MySQL, and
+// SQLITE_CONSTRAINT_UNIQUE or SQLITE_CONSTRAINT_PRIMARYKEY for SQLite. The drivers are not
+// imported, so their error types are recognized by name.
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.Indirect(reflect.ValueOf(err))
+		switch v.Type().String() {
+		case "mysql.MySQLError":
+			return v.FieldByName("Number").Uint() == 1062
+		case "sqlite3.Error":
+			code := v.FieldByName("ExtendedCode").Int()
+			return code == 2067 || code == 1555
+		}
+	}
+	return false
+}
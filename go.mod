@@ -1,6 +1,6 @@
 module github.com/ztcollazo/weasel
 
-go 1.18
+go 1.21
 
 require (
 	github.com/Masterminds/squirrel v1.5.3
@@ -1,11 +1,16 @@
 package weasel
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 
+	sq "github.com/Masterminds/squirrel"
 	"github.com/carlmjohnson/truthy"
+	"github.com/jmoiron/sqlx"
 )
 
 type whereable interface {
@@ -16,52 +21,152 @@ type whereable interface {
 // It has many of the methods used in the model, and contains all of the
 // querying utilities.
 type Group[Doc DocumentBase] struct {
-	Where     whereable
-	Model     *Model[Doc]
-	innerJoin string
-	on        string
-	id        string
-	groups    map[string]*Group[Doc]
-	order     string
+	Where    whereable
+	Model    *Model[Doc]
+	join     string
+	rel      *Relation
+	owner    any
+	groups   map[string]*Group[Doc]
+	order    string
+	lock     lock
+	scopes   []func(SelectManyQuery[Doc]) SelectManyQuery[Doc]
+	unscoped bool
+	only     map[string]bool
+	noCache  bool
+	conn     *Connection
+	err      error
 }
 
+// ScopeFunc is a named, parameterized query registered with Model.Scope. It receives the query
+// of the group it is applied to, and the arguments passed to Scoped.
+type ScopeFunc[Doc DocumentBase] func(q SelectManyQuery[Doc], args ...any) SelectManyQuery[Doc]
+
+// NewGroupWith creates a group from its raw parts. The group is inner joined with
+// `innerJoin ON (on = id)` if innerJoin is set. Relations should use NewRelationGroup.
 func NewGroupWith[Doc DocumentBase](where whereable, model *Model[Doc], innerJoin, on, id, order string, groups map[string]*Group[Doc]) *Group[Doc] {
+	join := innerJoin
+	if truthy.Value(on) {
+		join += " ON (" + on + " = " + id + ")"
+	}
 	return &Group[Doc]{
-		Where:     where,
-		Model:     model,
-		innerJoin: innerJoin,
-		on:        on,
-		id:        id,
-		order:     order,
-		groups:    groups,
+		Where:  where,
+		Model:  model,
+		join:   join,
+		order:  order,
+		groups: groups,
 	}
 }
 
+// NewRelationGroup creates the group of documents in model that owner has many of, as described
+// by rel. For through relations, the group is joined with the join table. Creating a document
+// in the group sets its foreign key, or inserts the join table row.
+func NewRelationGroup[Doc DocumentBase](model *Model[Doc], rel Relation, owner DocumentBase) *Group[Doc] {
+	g := &Group[Doc]{
+		Model:  model,
+		rel:    &rel,
+		groups: make(map[string]*Group[Doc]),
+		conn:   model.on(owner).conn,
+	}
+	if rel.Through != "" {
+		g.owner = owner.Get(owner.PrimaryKey())
+		g.Where = Eq{rel.Through + "." + rel.Key: g.owner}
+	} else if rel.Polymorphic != "" {
+		g.owner = owner.Get(rel.Key)
+		g.Where = Eq{model.tableName + "." + rel.ForeignKey: g.owner, model.tableName + "." + rel.TypeKey(): owner.Table()}
+	} else {
+		g.owner = owner.Get(rel.Key)
+		g.Where = Eq{model.tableName + "." + rel.ForeignKey: g.owner}
+	}
+	return g
+}
+
 // Find takes the primary key value and finds the corresponding document.
-func (m Group[Doc]) Find(value any) (Doc, error) {
-	stmt := Select([]string{m.Model.tableName + ".*"}, m.Model).Where(m.Where).Where(Eq{m.Model.tableName + "." + m.Model.pk: value})
-	if truthy.Value(m.innerJoin) {
-		stmt = stmt.InnerJoin(m.innerJoin + " ON (" + m.on + " = " + m.id + ")")
+func (m Group[Doc]) Find(value any) (doc Doc, err error) {
+	m, done := m.operation("Find")
+	defer func() { finish(done, err, doc) }()
+	return m.findWhere(Eq{m.Model.tableName + "." + m.Model.pk: value})
+}
+
+// FindBy takes a column name and value and finds the corresponding document.
+// If you want to find multiple, use All().Where(weasel.Eq{key: value}).
+func (m Group[Doc]) FindBy(name string, value any) (doc Doc, err error) {
+	m, done := m.operation("FindBy")
+	defer func() { finish(done, err, doc) }()
+	return m.findWhere(Eq{name: value})
+}
+
+// FindOrCreateBy finds the first document in the group matching attrs. If there is none, it
+// creates one from defaults, with attrs and the group's where attributes applied to it.
+// The lookup and the insert are separate queries, so two callers may both create a document;
+// use CreateOrFind if the table has a unique constraint on attrs.
+//
+//	Person.FindOrCreateBy(map[string]any{"email": "john@doe.com"}, &PersonSchema{FirstName: "John"})
+func (m Group[Doc]) FindOrCreateBy(attrs map[string]any, defaults Doc) (Doc, error) {
+	doc, err := m.findWhere(Eq(attrs))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return doc, err
+	}
+	m.assign(defaults, attrs)
+	return m.Create(defaults)
+}
+
+// CreateOrFind tries to create a document from defaults, with attrs and the group's where
+// attributes applied to it. If the insert violates a unique constraint, it returns the document
+// matching attrs instead. It needs a unique constraint on attrs in the database; with one, it is
+// safe to call concurrently. Other errors, including failed validations, are returned as they are.
+// In a transaction, the insert runs in a savepoint, so that the lookup can run after a violation.
+func (m Group[Doc]) CreateOrFind(attrs map[string]any, defaults Doc) (Doc, error) {
+	m.assign(defaults, attrs)
+	var doc Doc
+	err := m.connection().current().savepoint(func() (err error) {
+		doc, err = m.Create(defaults)
+		return err
+	})
+	if err == nil || !isUniqueViolation(err) {
+		return doc, err
 	}
-	doc, err := stmt.Exec()
+	if found, ferr := m.findWhere(Eq(attrs)); ferr == nil {
+		return found, nil
+	}
+	return doc, err
+}
+
+// FirstOrInit returns the first document in the group matching attrs. If there is none, it
+// returns a new, unsaved document built from the group's where attributes and attrs.
+// Pass it to Create to save it.
+//
+//	p, _ := Person.FromGroup("FromUS").FirstOrInit(map[string]any{"first_name": "John"})
+//	p.PlaceId //=> 1
+func (m Group[Doc]) FirstOrInit(attrs map[string]any) (Doc, error) {
+	matching := m
+	matching.Where = And{m.Where, Eq(attrs)}
+	doc, err := matching.First()
 	if err == nil {
-		callInit(doc, m.Model)
-		if len(doc.AllErrors()) > 0 {
-			return doc, errors.New("document is invalid")
-		}
 		return doc, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return doc, err
 	}
-	return doc, err
+	doc = clone(m.Model)
+	m.assign(doc, attrs)
+	bind(doc, m.connection())
+	callInit(doc, m.Model)
+	return doc, nil
 }
 
-// FindBy takes a column name and value and finds the corresponding document.
-// If you want to find multiple, use All().Where(weasel.Eq{key: value}).
-func (m Group[Doc]) FindBy(name string, value any) (Doc, error) {
-	stmt := Select([]string{"*"}, m.Model).Where(m.Where).Where(Eq{name: value})
-	if truthy.Value(m.innerJoin) {
-		stmt = stmt.InnerJoin(m.innerJoin, m.on)
+// operation starts the operation name (see OperationHook) on a copy of the group, whose queries
+// run in it. End it with finish.
+func (m Group[Doc]) operation(name string) (Group[Doc], func(err error)) {
+	event := OperationEvent{Model: m.Model.tableName, Name: name}
+	if m.rel != nil {
+		event.Relation = m.rel.Name
 	}
-	doc, err := stmt.Exec()
+	conn, done := m.connection().operation(event)
+	m.conn = &conn
+	return m, done
+}
+
+func (m Group[Doc]) findWhere(pred any) (Doc, error) {
+	doc, err := m.selectOne().Where(pred).Exec()
 	if err == nil {
 		callInit(doc, m.Model)
 		if len(doc.AllErrors()) > 0 {
@@ -72,36 +177,279 @@ func (m Group[Doc]) FindBy(name string, value any) (Doc, error) {
 	return doc, err
 }
 
+// attributes returns the equality conditions of the group's where clause (and of the default scope)
+// that map to fields of the model, for example {"place_id": 1} for a group created with
+// weasel.Eq{"place_id": 1}.
+func (m Group[Doc]) attributes() map[string]any {
+	attrs := make(map[string]any)
+	var collect func(w any)
+	collect = func(w any) {
+		switch c := w.(type) {
+		case Eq:
+			for k, v := range c {
+				k = strings.TrimPrefix(k, m.Model.tableName+".")
+				if _, ok := m.Model.fields[k]; !ok || v == nil {
+					continue
+				}
+				if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+					continue
+				}
+				attrs[k] = v
+			}
+		case And:
+			for _, e := range c {
+				collect(e)
+			}
+		}
+	}
+	if where, _ := m.Model.defaults(m.unscoped); where != nil {
+		collect(where)
+	}
+	collect(m.Where)
+	return attrs
+}
+
+// assign sets the group's where attributes, then attrs, on the document, which may not be
+// initialised yet.
+func (m Group[Doc]) assign(doc Doc, attrs map[string]any) {
+	for k, v := range m.attributes() {
+		m.Model.layout.set(doc, k, v)
+	}
+	for k, v := range attrs {
+		m.Model.layout.set(doc, k, v)
+	}
+}
+
 // All returns all of the documents in the group or model.
 // It returns a query builder that contains functions including Where, OrderBy, GroupBy.
 // For more information and functions, see SelectManyQuery and its methods.
 func (m Group[Doc]) All() SelectManyQuery[Doc] {
-	stmt := SelectMany([]string{"*"}, m.Model).Where(m.Where).OrderBy(m.order)
-	if truthy.Value(m.innerJoin) {
-		stmt = stmt.InnerJoin(m.innerJoin, m.on)
+	return m.query(true)
+}
+
+// query builds the query of the group's documents, with its scopes applied.
+func (m Group[Doc]) query(ordered bool) SelectManyQuery[Doc] {
+	return m.selecting(m.Model.columns(m.only), ordered)
+}
+
+// selecting builds the query of the given columns of the group's documents. See query.
+func (m Group[Doc]) selecting(columns []string, ordered bool) SelectManyQuery[Doc] {
+	conn := m.connection()
+	stmt := selectMany(conn, columns, m.Model).Where(m.Where)
+	if tenant, err := m.Model.tenantWhere(conn); err != nil {
+		stmt.err = err
+	} else if len(tenant) > 0 {
+		stmt = stmt.Where(tenant)
+	}
+	if where, _ := m.Model.defaults(m.unscoped); where != nil {
+		stmt = stmt.Where(where)
+	}
+	if m.rel != nil && m.rel.Through != "" {
+		stmt = stmt.InnerJoin(conn.Table(m.rel.Through) + " ON (" + m.rel.Through + "." + m.rel.ForeignKey + " = " + m.Model.tableName + "." + m.Model.pk + ")")
+	} else if truthy.Value(m.join) {
+		stmt = stmt.InnerJoin(m.join)
+	}
+	for _, scope := range m.scopes {
+		stmt = scope(stmt)
+	}
+	if ordered {
+		stmt = stmt.OrderBy(m.GetOrder())
+	}
+	stmt.lock = m.lock
+	stmt.noCache = m.noCache
+	if m.rel != nil {
+		stmt.relation = m.rel.Name
+	}
+	if m.err != nil {
+		stmt.err = m.err
 	}
 	return stmt
 }
 
-// Create creates a document and adds it to the database
-// TODO: Make create conform to `where` clause
-func (m Group[Doc]) Create(d Doc) (Doc, error) {
+// selectOne builds the query used to read a single document of the group.
+func (m Group[Doc]) selectOne() SelectQuery[Doc] {
+	return m.query(false).one()
+}
+
+// Select returns a copy of the group that only loads the given columns (and the primary key).
+// The other fields of the documents it loads are left as zero values and marked as not loaded
+// (see Document.IsLoaded), so that Save does not overwrite them. For example:
+//
+//	Person.Select("first_name", "email").All().Exec()
+func (m Group[Doc]) Select(columns ...string) *Group[Doc] {
+	m.only = map[string]bool{m.Model.pk: true}
+	for _, c := range columns {
+		if _, ok := m.Model.fields[c]; !ok {
+			m.err = fmt.Errorf("%s has no column %s", m.Model.tableName, c)
+		}
+		m.only[c] = true
+	}
+	return &m
+}
+
+// Omit returns a copy of the group that does not load the given columns, for example large text
+// columns. The primary key is always loaded. See Select.
+func (m Group[Doc]) Omit(columns ...string) *Group[Doc] {
+	only := make(map[string]bool, len(m.Model.fields))
+	for c := range m.Model.fields {
+		if m.only == nil || m.only[c] {
+			only[c] = true
+		}
+	}
+	for _, c := range columns {
+		if _, ok := m.Model.fields[c]; !ok {
+			m.err = fmt.Errorf("%s has no column %s", m.Model.tableName, c)
+		} else if c != m.Model.pk {
+			delete(only, c)
+		}
+	}
+	m.only = only
+	return &m
+}
+
+// Unscoped returns a copy of the group without the model's default scope (see Model.DefaultScope).
+func (m Group[Doc]) Unscoped() *Group[Doc] {
+	m.unscoped = true
+	return &m
+}
+
+// Scoped returns a copy of the group narrowed by the named scope, registered with Model.Scope,
+// called with args. Groups created with CreateGroup can be used as scopes too. Scoped groups
+// can be chained, for example:
+//
+//	Person.Scoped("FromUS").Scoped("Recent", 7).All().Exec()
+//
+// If there is no scope with that name, the group's queries return an error.
+func (m Group[Doc]) Scoped(name string, args ...any) *Group[Doc] {
+	m.scopes = m.scopes[:len(m.scopes):len(m.scopes)]
+	m.Model.mu.RLock()
+	g, ok := m.groups[name]
+	if !ok {
+		g, ok = m.Model.groups[name]
+	}
+	scope, isScope := m.Model.scopes[name]
+	m.Model.mu.RUnlock()
+	if ok {
+		m.Where = And{m.Where, g.Where}
+		m.scopes = append(m.scopes, g.scopes...)
+	} else if isScope {
+		m.scopes = append(m.scopes, func(q SelectManyQuery[Doc]) SelectManyQuery[Doc] {
+			return scope(q, args...)
+		})
+	} else if m.err == nil {
+		m.err = fmt.Errorf("%s has no scope %s", m.Model.tableName, name)
+	}
+	return &m
+}
+
+// ForUpdate returns a copy of the group whose reads lock the selected rows (FOR UPDATE).
+// It applies to Find, FindBy, All and the Nth family. The locks are held until the transaction
+// ends, so read through a connection given by Connection.Transaction; outside of one, each
+// statement commits on its own and the lock is released as soon as the read returns. For example:
+//
+//	err := conn.Transaction(func(tx weasel.Connection) error {
+//		item, err := Item.Using(tx).ForUpdate().Find(1)
+//		if err != nil {
+//			return err
+//		}
+//		item.Stock--
+//		return item.Save() // The row stays locked until the transaction commits
+//	})
+func (m Group[Doc]) ForUpdate() *Group[Doc] {
+	m.lock = m.lock.forUpdate()
+	return &m
+}
+
+// ForShare returns a copy of the group whose reads take a shared lock (FOR SHARE). Like
+// ForUpdate, the lock only lasts until the end of the transaction the read runs in.
+func (m Group[Doc]) ForShare() *Group[Doc] {
+	m.lock = m.lock.forShare()
+	return &m
+}
+
+// NoWait returns a copy of the group whose locking reads fail instead of waiting on locked rows.
+// If no lock strength was set, it implies ForUpdate.
+func (m Group[Doc]) NoWait() *Group[Doc] {
+	m.lock = m.lock.noWait()
+	return &m
+}
+
+// SkipLocked returns a copy of the group whose locking reads skip rows that are already locked.
+// If no lock strength was set, it implies ForUpdate.
+func (m Group[Doc]) SkipLocked() *Group[Doc] {
+	m.lock = m.lock.skipLocked()
+	return &m
+}
+
+// Create creates a document and adds it to the database. The equality conditions of the group's
+// where clause are copied into the document first, so that it belongs to the group; for example,
+// creating through Place.People() sets the person's place_id. For has many through groups, the
+// join table row is inserted too, in the same transaction. Documents attached with Attach are
+// created with it, also in the same transaction.
+func (m Group[Doc]) Create(d Doc) (doc Doc, err error) {
+	m, done := m.operation("Create")
+	defer func() { finish(done, err, doc) }()
+	m.assign(d, nil)
+	if (m.rel == nil || m.rel.Through == "") && !hasAttachments(d) && !m.Model.hasCounters() {
+		return m.create(m.connection(), d)
+	}
+	err = m.connection().Transaction(func(tx Connection) error {
+		var err error
+		doc, err = m.createWith(tx, d)
+		return err
+	})
+	return doc, err
+}
+
+// createWith creates the document on conn, along with its join table row and attached documents.
+func (m Group[Doc]) createWith(conn Connection, d Doc) (Doc, error) {
+	attached := any(d).(persistence).attachments()
+	if err := m.Model.saveParents(conn, d); err != nil {
+		return d, err
+	}
+	doc, err := m.create(conn, d)
+	if err != nil {
+		return doc, err
+	}
+	if m.rel != nil && m.rel.Through != "" {
+		_, err = conn.Builder.Insert(conn.Table(m.rel.Through)).
+			Columns(m.rel.Key, m.rel.ForeignKey).
+			Values(m.owner, doc.Get(m.Model.pk)).
+			Exec()
+		conn.onCommit(m.Model.invalidate)
+		if err != nil {
+			return doc, err
+		}
+	}
+	if err := m.Model.saveChildren(conn, doc, attached); err != nil {
+		return doc, err
+	}
+	any(d).(persistence).detach()
+	return doc, nil
+}
+
+func (m Group[Doc]) create(conn Connection, d Doc) (Doc, error) {
+	if err := m.Model.assignTenant(conn, d); err != nil {
+		return d, err
+	}
+	bind(d, conn)
 	callInit(d, m.Model)
 	if len(d.AllErrors()) > 0 {
 		return d, errors.New("document is invalid")
 	}
 	v := reflect.Indirect(reflect.ValueOf(d))
-	columns := make([]string, 0)
-	values := make([]any, 0)
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		t := v.Type().Field(i)
-		if f, ok := m.Model.fields[t.Tag.Get("db")]; ok && !f.PrimaryKey {
-			columns = append(columns, f.DBName)
-			values = append(values, field.Interface())
+	columns := make([]string, 0, len(m.Model.layout.order))
+	values := make([]any, 0, len(m.Model.layout.order))
+	for _, column := range m.Model.layout.order {
+		if !m.Model.fields[column].PrimaryKey {
+			columns = append(columns, column)
+			values = append(values, v.FieldByIndex(m.Model.layout.columns[column]).Interface())
 		}
 	}
-	doc, err := Insert(m.Model).Columns(columns...).Values(values...).Exec()
+	doc, err := insert(conn, m.Model).Columns(columns...).Values(values...).Exec()
+	if err == nil {
+		err = m.Model.countAll(conn, doc, 1)
+	}
 	if err == nil {
 		callInit(doc, m.Model)
 		// And just in case
@@ -115,43 +463,126 @@ func (m Group[Doc]) Create(d Doc) (Doc, error) {
 
 // CreateGroup adds a group to the model or group that can be accessed by FromGroup.
 // It respects the current group's where clause and contains all of the querying functionality
-// and utilities.
+// and utilities. It is safe to call while the model is in use.
 func (m *Group[Doc]) CreateGroup(name string, expr whereable) {
-	m.groups[name] = &Group[Doc]{
-		Where:  And{m.Where, expr},
-		Model:  m.Model,
-		groups: make(map[string]*Group[Doc]),
-	}
+	g := *m
+	g.Where = And{m.Where, expr}
+	g.groups = make(map[string]*Group[Doc])
+	m.Model.mu.Lock()
+	defer m.Model.mu.Unlock()
+	m.groups[name] = &g
 }
 
 // FromGroup returns the group that the name parameter points to.
 // See CreateGroup() and Group for more information.
 func (m Group[Doc]) FromGroup(name string) *Group[Doc] {
+	m.Model.mu.RLock()
+	defer m.Model.mu.RUnlock()
 	return m.groups[name]
 }
 
 // Count returns the number of documents in the group or model.
 func (m Group[Doc]) Count() (int, error) {
-	var cnt int
-	err := m.Model.Conn.Builder.Select("COUNT(*)").From(m.Model.tableName).Where(m.Where).Scan(&cnt)
-	return cnt, err
+	return m.count(m.query(false))
 }
 
 // Exists checks if the document with the given primary key exists.
 func (m Group[Doc]) Exists(id any) (bool, error) {
-	var cnt int
-	err := m.Model.Conn.Builder.Select("COUNT(1)").From(m.Model.tableName).Where(m.Where).Where(Eq{m.Model.pk: id}).Scan(&cnt)
+	cnt, err := m.count(m.query(false).Where(Eq{m.Model.tableName + "." + m.Model.pk: id}))
 	return cnt != 0, err
 }
 
-// Order sets the order that the documents should be sorted by when queried.
+// UpdateAll sets the columns of all of the documents in the group with one statement, and returns
+// the number of rows updated. The documents are not loaded, so their validations, counter caches
+// and nested relations are skipped. For example:
+//
+//	Person.FromGroup("FromUS").UpdateAll(map[string]any{"email": nil})
+func (m Group[Doc]) UpdateAll(set map[string]any) (int64, error) {
+	conn := m.connection()
+	within, err := m.within()
+	if err != nil {
+		return 0, err
+	}
+	res, err := conn.Instrument(m.Model.tableName, OpSave).Builder.Update(conn.Table(m.Model.tableName)).SetMap(set).Where(within).Exec()
+	conn.onCommit(m.Model.invalidate)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteAll deletes all of the documents in the group with one statement, and returns the number
+// of rows deleted. The documents are not loaded, so their dependent actions and counter caches
+// are skipped; load and Delete them one by one for those.
+func (m Group[Doc]) DeleteAll() (int64, error) {
+	conn := m.connection()
+	within, err := m.within()
+	if err != nil {
+		return 0, err
+	}
+	res, err := conn.Instrument(m.Model.tableName, OpDelete).Builder.Delete(conn.Table(m.Model.tableName)).Where(within).Exec()
+	conn.onCommit(m.Model.invalidate)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// within returns a condition on the primary key that matches the documents of the group, with its
+// joins, scopes and tenant, for statements that cannot join such as UPDATE and DELETE.
+func (m Group[Doc]) within() (sq.Sqlizer, error) {
+	pk := m.Model.tableName + "." + m.Model.pk
+	q := m.selecting([]string{pk}, false)
+	if q.err != nil {
+		return nil, q.err
+	}
+	return sq.Expr(pk+" IN (?)", q.ordered().PlaceholderFormat(sq.Question)), nil
+}
+
+// count counts the rows of the query, as a subquery so that scopes with limits are respected.
+func (m Group[Doc]) count(q SelectManyQuery[Doc]) (int, error) {
+	var cnt int
+	if q.err != nil {
+		return cnt, q.err
+	}
+	query, args, err := q.conn.Builder.Select("COUNT(*)").FromSelect(q.ordered(), "counted").ToSql()
+	if err != nil {
+		return cnt, err
+	}
+	event := QueryEvent{Model: m.Model.tableName, Operation: OpCount, SQL: query, Args: args}
+	err = q.conn.read(event, q.lock.locking(), func(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+		return 1, db.QueryRowxContext(ctx, query, args...).Scan(&cnt)
+	})
+	return cnt, err
+}
+
+// OrderBy returns a new group whose documents are sorted by the order when queried, for example
+// "first_name DESC"; the receiver is unchanged, so that it can be shared. Use Model.DefaultScope to
+// change the order of all of the model's queries. For example:
+//
+//	Person.OrderBy("first_name DESC").All().Exec()
+func (m Group[Doc]) OrderBy(by string) *Group[Doc] {
+	m.order = by
+	return &m
+}
+
+// Order sets the order that the documents should be sorted by when queried, changing the group
+// in place.
+//
+// Deprecated: Order is not safe to call while the group is used by other goroutines. Use OrderBy,
+// which returns a new group, or Model.DefaultScope to change the order of all of a model's queries.
 func (m *Group[Doc]) Order(by string) {
 	m.order = by
 }
 
-// Get order returns the order of the documents used when queried, for example "id ASC"
+// Get order returns the order of the documents used when queried, for example "id ASC". Unless
+// set with OrderBy, it is the order of the model's default scope.
 func (m *Group[Doc]) GetOrder() string {
-	return m.order
+	if m.order != "" {
+		return m.order
+	}
+	_, order := m.Model.defaults(m.unscoped)
+	return order
 }
 
 // First returns the first document from the table, via the set order clause.
@@ -197,23 +628,66 @@ func (m Group[Doc]) SecondToLast() (Doc, error) {
 }
 
 // NthToLast returns the last document at the given index.
+// The order of the group's scopes comes before the group's order, and both are inverted.
 // For example:
 //
 //	Person.NthToLast(3) // Returns the third to last document.
-func (m Group[Doc]) NthToLast(id int) (Doc, error) {
-	var order string
-	if strings.Contains(m.order, "ASC") {
-		order = strings.Replace(m.order, "ASC", "DESC", 1)
-	} else {
-		order = strings.Replace(m.order, "DESC", "ASC", 1)
-	}
-	return Select([]string{"*"}, m.Model).Where(m.Where).Limit(1).OrderBy(order).Offset(uint64(id - 1)).Exec()
+func (m Group[Doc]) NthToLast(id int) (doc Doc, err error) {
+	m, done := m.operation("NthToLast")
+	defer func() { finish(done, err, doc) }()
+	return m.nth(id, true)
 }
 
 // Nth returns the document at the given index.
+// If a scope limits the group, the index counts within the scope's documents.
 // For example:
 //
 //	Person.Nth(6) // Returns the sixth document.
-func (m Group[Doc]) Nth(id int) (Doc, error) {
-	return Select([]string{"*"}, m.Model).Where(m.Where).Limit(1).Offset(uint64(id - 1)).Exec()
+func (m Group[Doc]) Nth(id int) (doc Doc, err error) {
+	m, done := m.operation("Nth")
+	defer func() { finish(done, err, doc) }()
+	return m.nth(id, false)
+}
+
+// nth builds the query of the Nth family once the scopes are applied, so that their ORDER BY is
+// part of the order that NthToLast inverts, and so that their LIMIT and OFFSET pick the documents
+// to count from instead of conflicting with the LIMIT and OFFSET of the index.
+func (m Group[Doc]) nth(id int, last bool) (Doc, error) {
+	q := m.query(true)
+	if q.windowed {
+		pk := m.Model.tableName + "." + m.Model.pk
+		window := m.selecting([]string{pk}, true)
+		q.builder = q.builder.RemoveLimit().RemoveOffset().Where(sq.Expr(pk+" IN (?)", window.ordered().PlaceholderFormat(sq.Question)))
+		q.windowed = false
+	}
+	if last {
+		orders := make([]string, len(q.orders))
+		for i, o := range q.orders {
+			orders[i] = invert(o)
+		}
+		q.orders = orders
+	}
+	doc, err := q.one().Limit(1).Offset(uint64(id - 1)).Exec()
+	if err == nil {
+		callInit(doc, m.Model)
+	}
+	return doc, err
+}
+
+// invert reverses the direction of each of the expressions of an ORDER BY clause.
+func invert(order string) string {
+	terms := strings.Split(order, ",")
+	for i, t := range terms {
+		t = strings.TrimSpace(t)
+		upper := strings.ToUpper(t)
+		switch {
+		case strings.HasSuffix(upper, " DESC"):
+			terms[i] = t[:len(t)-5] + " ASC"
+		case strings.HasSuffix(upper, " ASC"):
+			terms[i] = t[:len(t)-4] + " DESC"
+		default:
+			terms[i] = t + " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
 }
@@ -0,0 +1,264 @@
+package weasel
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/carlmjohnson/truthy"
+)
+
+// Operation is the kind of work a query does for weasel, reported to query hooks.
+type Operation string
+
+const (
+	OpFind     Operation = "find"     // Find, FindBy, the Nth family, relation lookups and Reload
+	OpAll      Operation = "all"      // All and the other select many queries
+	OpCount    Operation = "count"    // Count and Exists
+	OpInsert   Operation = "insert"   // Create and Insert
+	OpSave     Operation = "save"     // Save and UpdateAll
+	OpDelete   Operation = "delete"   // Delete and DeleteAll
+	OpValidate Operation = "validate" // Validations such as use.ValidateUniquenessOf
+	OpExec     Operation = "exec"     // Any other statement: join tables, counter caches, dependent actions, raw queries
+)
+
+// QueryEvent describes a query run by weasel.
+type QueryEvent struct {
+	Driver    string        // The name of the connection's driver, for example postgres
+	Model     string        // The table of the model, if the query is run for one
+	Operation Operation     // What the query does
+	SQL       string        // The query
+	Args      []any         // The arguments of the query
+	Duration  time.Duration // How long the query took, set after it ran
+	Rows      int64         // The rows affected by writes, or read by reads, set after it ran
+	Err       error         // The error of the query, set after it ran
+}
+
+// QueryHook is called around every query run by weasel. BeforeQuery can return a derived context,
+// for example to start a span, which is passed to the database driver and to AfterQuery. Hooks are
+// called in the order they were added, and in reverse order after the query. See Connection.AddHook.
+type QueryHook interface {
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// OperationEvent describes a call to weasel that runs queries for the caller, such as Find or Save.
+type OperationEvent struct {
+	Model    string        // The table of the model
+	Name     string        // The method, for example Find, All (for SelectManyQuery.Exec), Create or Save
+	Relation string        // The relation field the documents are loaded through, if any
+	Duration time.Duration // How long the operation took, set after it ran
+	Err      error         // The error of the operation, set after it ran
+}
+
+// OperationHook can be implemented by a QueryHook to be called around weasel's operations too:
+// Group.Find and FindBy, the Nth family, SelectManyQuery.Exec, Group.Create, Document.Save and
+// Delete, and relation loads. An operation may run several queries (validations, counter caches,
+// transactions...), and nest other operations. The context returned by BeforeOperation is the
+// parent of all of them, and is passed to AfterOperation.
+type OperationHook interface {
+	BeforeOperation(ctx context.Context, event *OperationEvent) context.Context
+	AfterOperation(ctx context.Context, event *OperationEvent)
+}
+
+// hooks holds the query hooks of a connection, shared by all of its copies.
+type hooks struct {
+	mu     sync.RWMutex
+	list   []QueryHook
+	driver string
+}
+
+func (h *hooks) all() []QueryHook {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.list
+}
+
+// begin calls the before hooks for the event. It returns the context to run the query with, and
+// the function to call with its outcome, which calls the after hooks.
+func (h *hooks) begin(ctx context.Context, event QueryEvent) (context.Context, func(rows int64, err error)) {
+	list := h.all()
+	if len(list) == 0 {
+		return ctx, func(int64, error) {}
+	}
+	event.Driver = h.driver
+	for _, hook := range list {
+		ctx = hook.BeforeQuery(ctx, &event)
+	}
+	start := time.Now()
+	return ctx, func(rows int64, err error) {
+		if err != nil {
+			rows = 0
+		}
+		event.Duration = time.Since(start)
+		event.Rows = rows
+		event.Err = err
+		for i := len(list) - 1; i >= 0; i-- {
+			list[i].AfterQuery(ctx, &event)
+		}
+	}
+}
+
+// operate calls the before operation hooks for the event, like begin. It returns false if no hook
+// implements OperationHook.
+func (h *hooks) operate(ctx context.Context, event OperationEvent) (context.Context, func(err error), bool) {
+	list := make([]OperationHook, 0)
+	for _, hook := range h.all() {
+		if o, ok := hook.(OperationHook); ok {
+			list = append(list, o)
+		}
+	}
+	if len(list) == 0 {
+		return ctx, func(error) {}, false
+	}
+	for _, hook := range list {
+		ctx = hook.BeforeOperation(ctx, &event)
+	}
+	start := time.Now()
+	return ctx, func(err error) {
+		event.Duration = time.Since(start)
+		event.Err = err
+		for i := len(list) - 1; i >= 0; i-- {
+			list[i].AfterOperation(ctx, &event)
+		}
+	}, true
+}
+
+// operation starts an operation (see OperationHook) on the connection. It returns a copy of the
+// connection whose queries carry the operation's context, and the function to call with the
+// operation's error once it is over.
+func (c Connection) operation(event OperationEvent) (Connection, func(err error)) {
+	ctx, done, ok := c.hooks.operate(c.Context(), event)
+	if !ok {
+		return c, done
+	}
+	if !c.operating {
+		c.caller = c.ctx
+		c.operating = true
+	}
+	c.ctx = ctx
+	c.rewire()
+	return c, done
+}
+
+// released returns the connection with the context of the caller of its operations, if any, to
+// bind the documents the operations return to.
+func (c Connection) released() Connection {
+	if c.operating {
+		c.ctx = c.caller
+		c.caller = nil
+		c.operating = false
+		c.rewire()
+	}
+	return c
+}
+
+// finish rebinds the documents returned by an operation to the caller's context, so that their
+// own queries are not part of it, and ends the operation.
+func finish[Doc any](done func(err error), err error, docs ...Doc) {
+	for _, doc := range docs {
+		if d, ok := any(doc).(binder); ok && truthy.Value(d) {
+			d.release()
+		}
+	}
+	done(err)
+}
+
+// AddHook adds a query hook to the connection. It applies to every copy of the connection,
+// including those held by models and documents, for example:
+//
+//	conn.AddHook(&weasel.Logger{SlowThreshold: 100 * time.Millisecond})
+func (c Connection) AddHook(hook QueryHook) {
+	if c.hooks == nil {
+		return
+	}
+	c.hooks.mu.Lock()
+	defer c.hooks.mu.Unlock()
+	c.hooks.list = append(c.hooks.list[:len(c.hooks.list):len(c.hooks.list)], hook)
+}
+
+// Instrument returns a copy of the connection whose Builder reports its statements to the query
+// hooks with the given model and operation. Use it for custom queries and validations, for example:
+//
+//	d.Conn().Instrument(d.Table(), weasel.OpValidate).Builder.Select("COUNT(*)")...
+func (c Connection) Instrument(model string, op Operation) Connection {
+	c.model = model
+	c.op = op
+	c.rewire()
+	return c
+}
+
+// rewire makes the connection's Builder run on the current transaction or database, reporting
+// to the hooks with the connection's context, model and operation.
+func (c *Connection) rewire() {
+	runner := c.runner()
+	if c.tx != nil {
+		runner = c.tx
+	}
+	op := c.op
+	if op == "" {
+		op = OpExec
+	}
+	c.Builder = c.Builder.RunWith(hookRunner{runner: c.prepared(runner).(stdRunner), hooks: c.hooks, ctx: c.Context(), model: c.model, op: op})
+}
+
+// observe runs fn as the query described by the event, reporting it to the connection's hooks.
+func (c Connection) observe(event QueryEvent, fn func(ctx context.Context) (int64, error)) error {
+	ctx, done := c.hooks.begin(c.Context(), event)
+	rows, err := fn(ctx)
+	done(rows, err)
+	return err
+}
+
+// stdRunner is what statements run on: a database, a transaction, or a replica writer.
+type stdRunner interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// hookRunner runs the statements of a Builder, reporting them to the hooks.
+type hookRunner struct {
+	runner stdRunner
+	hooks  *hooks
+	ctx    context.Context
+	model  string
+	op     Operation
+}
+
+func (r hookRunner) event(query string, args []any) QueryEvent {
+	return QueryEvent{Model: r.model, Operation: r.op, SQL: query, Args: args}
+}
+
+func (r hookRunner) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, done := r.hooks.begin(r.ctx, r.event(query, args))
+	res, err := r.runner.ExecContext(ctx, query, args...)
+	var rows int64
+	if err == nil {
+		rows, _ = res.RowsAffected()
+	}
+	done(rows, err)
+	return res, err
+}
+
+func (r hookRunner) Query(query string, args ...any) (*sql.Rows, error) {
+	ctx, done := r.hooks.begin(r.ctx, r.event(query, args))
+	rows, err := r.runner.QueryContext(ctx, query, args...)
+	done(0, err)
+	return rows, err
+}
+
+// QueryRow calls the after hooks as soon as the query returns, rather than when the row is scanned,
+// so that a row that is never scanned does not leave a span or a timer open. The row is reported
+// as read unless the query failed; a query that matched no rows only fails once scanned.
+func (r hookRunner) QueryRow(query string, args ...any) sq.RowScanner {
+	ctx, done := r.hooks.begin(r.ctx, r.event(query, args))
+	row := r.runner.QueryRowContext(ctx, query, args...)
+	done(1, row.Err())
+	return row
+}
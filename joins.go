@@ -0,0 +1,116 @@
+package weasel
+
+import (
+	"fmt"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// relationJoin describes how to reach the table of a relation from the model's table in SQL.
+type relationJoin struct {
+	target      string // The related table, aliased if it is the model's own table
+	alias       string // The name to use for the related table in conditions
+	through     string // The join table of through relations
+	on          string // Joins target, or through for through relations, to the model's table
+	throughOn   string // Joins target to through, for through relations
+	args        []any  // The arguments of on
+	throughArgs []any  // The arguments of throughOn
+}
+
+// join returns how to join the relation with the given field name. When the relation points to
+// the model's own table, the related table is aliased as <field>_<table>, for example friends_person.
+func (m *Model[Doc]) join(conn Connection, name string) (relationJoin, error) {
+	rel, ok := m.relations[name]
+	if !ok {
+		return relationJoin{}, fmt.Errorf("%s has no relation %s", m.tableName, name)
+	}
+	if rel.Polymorphic != "" && rel.Variant == "belongsTo" {
+		return relationJoin{}, fmt.Errorf("cannot join polymorphic relation %s.%s", m.tableName, name)
+	}
+	j := relationJoin{target: conn.Table(rel.Table), alias: rel.Table}
+	if rel.Table == m.tableName {
+		j.alias = strings.ToLower(rel.Name) + "_" + rel.Table
+		j.target = conn.tableAs(rel.Table, j.alias)
+	}
+	switch {
+	case rel.Variant == "belongsTo":
+		j.on = fmt.Sprintf("%s.%s = %s.%s", j.alias, rel.ForeignKey, m.tableName, rel.Key)
+	case rel.Through != "":
+		pk := "id"
+		if target, ok := conn.registry.lookup(rel.Table); ok {
+			pk = target.primaryKey()
+		}
+		j.through = conn.Table(rel.Through)
+		j.on = fmt.Sprintf("%s.%s = %s.%s", rel.Through, rel.Key, m.tableName, m.pk)
+		j.throughOn = fmt.Sprintf("%s.%s = %s.%s", j.alias, pk, rel.Through, rel.ForeignKey)
+	default:
+		j.on = fmt.Sprintf("%s.%s = %s.%s", j.alias, rel.ForeignKey, m.tableName, rel.Key)
+		if rel.Polymorphic != "" {
+			j.on += fmt.Sprintf(" AND %s.%s = ?", j.alias, rel.TypeKey())
+			j.args = []any{m.tableName}
+		}
+	}
+	if err := j.restrict(conn, rel); err != nil {
+		return relationJoin{}, err
+	}
+	return j, nil
+}
+
+// restrict adds the tenant condition of the related model, if it has one, to the condition joining
+// its table, so that joins and EXISTS subqueries stay within the connection's tenant.
+func (j *relationJoin) restrict(conn Connection, rel Relation) error {
+	target, ok := conn.registry.lookup(rel.Table)
+	if !ok {
+		return nil
+	}
+	where, err := target.tenantWhereAs(conn, j.alias)
+	if err != nil || len(where) == 0 {
+		return err
+	}
+	cond, args, err := where.ToSql()
+	if err != nil {
+		return err
+	}
+	if j.through != "" {
+		j.throughOn += " AND " + cond
+		j.throughArgs = args
+	} else {
+		j.on += " AND " + cond
+		j.args = append(j.args, args...)
+	}
+	return nil
+}
+
+// apply inner joins the relation's tables on the builder.
+func (j relationJoin) apply(builder sq.SelectBuilder) sq.SelectBuilder {
+	if j.through != "" {
+		return builder.InnerJoin(j.through+" ON "+j.on).InnerJoin(j.target+" ON "+j.throughOn, j.throughArgs...)
+	}
+	return builder.InnerJoin(j.target+" ON "+j.on, j.args...)
+}
+
+// exists returns an EXISTS (or NOT EXISTS) condition on the relation's rows, narrowed by fns.
+func (j relationJoin) exists(not bool, fns []func(SelectBuilder) SelectBuilder) (sq.Sqlizer, error) {
+	var sub sq.SelectBuilder
+	if j.through != "" {
+		sub = sq.Select("1").From(j.through).InnerJoin(j.target+" ON "+j.throughOn, j.throughArgs...).Where(j.on)
+	} else {
+		sub = sq.Select("1").From(j.target).Where(j.on, j.args...)
+	}
+	for _, fn := range fns {
+		sub = fn(sub)
+	}
+	query, args, err := sub.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if not {
+		return sq.Expr("NOT EXISTS ("+query+")", args...), nil
+	}
+	return sq.Expr("EXISTS ("+query+")", args...), nil
+}
+
+func (m *Model[Doc]) primaryKey() string {
+	return m.pk
+}
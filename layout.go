@@ -0,0 +1,177 @@
+package weasel
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// layout is the reflection metadata of a schema, compiled once per model by Create. Documents
+// reach their fields by index through it, instead of looking them up by name on every access.
+type layout struct {
+	typ      reflect.Type     // The schema struct
+	columns  map[string][]int // Field indexes by column (the db tag)
+	names    map[string][]int // Field indexes by Go field name, including promoted fields
+	order    []string         // The columns, in struct order
+	scans    map[string][]int // Field indexes by result column, as sqlx maps them
+	creators [][]int          // The embedded structs with a Create method, such as Document
+}
+
+// creator is implemented by the Document embedded in every schema.
+type creator[Doc DocumentBase] interface {
+	Create(Doc, *Model[Doc])
+}
+
+func newLayout(t reflect.Type, fields map[string]Field, conn Connection) *layout {
+	l := &layout{
+		typ:     t,
+		columns: make(map[string][]int),
+		names:   make(map[string][]int),
+		scans:   make(map[string][]int),
+	}
+	for _, f := range reflect.VisibleFields(t) {
+		l.names[f.Name] = f.Index
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if column, ok := f.Tag.Lookup("db"); ok {
+			if _, ok := fields[column]; ok {
+				l.columns[column] = f.Index
+				l.order = append(l.order, column)
+			}
+		}
+		if _, ok := reflect.PointerTo(f.Type).MethodByName("Create"); f.Anonymous && ok {
+			l.creators = append(l.creators, f.Index)
+		}
+	}
+	mapper := reflectx.NewMapperFunc("db", sqlx.NameMapper)
+	if conn.DB != nil {
+		mapper = conn.DB.Mapper
+	}
+	for name, fi := range mapper.TypeMap(t).Names {
+		l.scans[name] = fi.Index
+	}
+	return l
+}
+
+// field returns the index of the field of a column or, failing that, of a Go field name.
+func (l *layout) field(name string) ([]int, bool) {
+	if index, ok := l.columns[name]; ok {
+		return index, true
+	}
+	index, ok := l.names[name]
+	return index, ok
+}
+
+// set sets the field of a column on doc, converting the value like Document.Set does. Unlike
+// Set, it works on documents that were built by hand and not initialised yet.
+func (l *layout) set(doc any, column string, value any) {
+	if index, ok := l.columns[column]; ok {
+		f := reflect.ValueOf(doc).Elem().FieldByIndex(index)
+		f.Set(convert(value, f.Type()))
+	}
+}
+
+// traversals returns the indexes of the fields to scan the columns of a result into.
+func (l *layout) traversals(columns []string) ([][]int, error) {
+	indexes := make([][]int, len(columns))
+	for i, column := range columns {
+		index, ok := l.scans[column]
+		if !ok {
+			return nil, fmt.Errorf("missing destination name %s in *%s", column, l.typ)
+		}
+		indexes[i] = index
+	}
+	return indexes, nil
+}
+
+// unloaded returns the columns of the schema that are not among the columns of a result, or nil
+// if there are none.
+func (l *layout) unloaded(columns []string) map[string]bool {
+	var unloaded map[string]bool
+	for _, c := range l.order {
+		if !slices.Contains(columns, c) {
+			if unloaded == nil {
+				unloaded = make(map[string]bool)
+			}
+			unloaded[c] = true
+		}
+	}
+	return unloaded
+}
+
+// scan scans the current row into the struct v.
+func scan(rows *sqlx.Rows, v reflect.Value, indexes [][]int, values []any) error {
+	for i, index := range indexes {
+		values[i] = reflectx.FieldByIndexes(v, index).Addr().Interface()
+	}
+	return rows.Scan(values...)
+}
+
+// get runs the query and scans its first row into the document, like sqlx.GetContext, marking
+// the fields the query did not select as not loaded. It returns the columns of the result, or
+// sql.ErrNoRows if there is no row.
+func (l *layout) get(ctx context.Context, db sqlx.QueryerContext, doc any, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := l.traversals(columns)
+	if err != nil {
+		return nil, err
+	}
+	if err := scan(rows, reflect.ValueOf(doc).Elem(), indexes, make([]any, len(columns))); err != nil {
+		return nil, err
+	}
+	if d, ok := doc.(loader); ok {
+		d.loaded(l.unloaded(columns))
+	}
+	return columns, rows.Close()
+}
+
+// selectDocs runs the query and scans its rows into new documents, replacing the contents of
+// dest once the query succeeded, like sqlx.SelectContext. See get.
+func selectDocs[Doc DocumentBase](ctx context.Context, db sqlx.QueryerContext, m *Model[Doc], dest *[]Doc, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	*dest = (*dest)[:0]
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := m.layout.traversals(columns)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(columns))
+	unloaded := m.layout.unloaded(columns)
+	for rows.Next() {
+		v := reflect.New(m.layout.typ)
+		if err := scan(rows, v.Elem(), indexes, values); err != nil {
+			return nil, err
+		}
+		doc := v.Interface().(Doc)
+		any(doc).(loader).loaded(unloaded)
+		*dest = append(*dest, doc)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,57 @@
+package weasel
+
+import (
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// lock holds the row locking clause of a select query. It is only rendered when the query
+// is executed, because the clause depends on the driver.
+type lock struct {
+	strength string // UPDATE or SHARE
+	wait     string // NOWAIT or SKIP LOCKED
+}
+
+func (l lock) forUpdate() lock {
+	l.strength = "UPDATE"
+	return l
+}
+
+func (l lock) forShare() lock {
+	l.strength = "SHARE"
+	return l
+}
+
+func (l lock) noWait() lock {
+	l.wait = "NOWAIT"
+	return l
+}
+
+func (l lock) skipLocked() lock {
+	l.wait = "SKIP LOCKED"
+	return l
+}
+
+// locking checks if the lock has a locking clause.
+func (l lock) locking() bool {
+	return l.strength != "" || l.wait != ""
+}
+
+// apply adds the locking clause to the builder. NoWait and SkipLocked without a lock
+// strength imply FOR UPDATE.
+func (l lock) apply(builder sq.SelectBuilder, driver string) (sq.SelectBuilder, error) {
+	if l.strength == "" && l.wait == "" {
+		return builder, nil
+	}
+	switch driver {
+	case "postgres", "mysql":
+		clause := "FOR " + or(l.strength, "UPDATE")
+		if l.wait != "" {
+			clause += " " + l.wait
+		}
+		return builder.Suffix(clause), nil
+	default:
+		return builder, fmt.Errorf("%w: %s", ErrLockUnsupported, driver)
+	}
+}
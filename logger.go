@@ -0,0 +1,63 @@
+package weasel
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Logger is a QueryHook that logs every query with log/slog. Queries that fail are logged at
+// the error level, and queries slower than SlowThreshold at the warn level. For example:
+//
+//	conn.AddHook(&weasel.Logger{
+//		Logger:        slog.Default(),
+//		SlowThreshold: 200 * time.Millisecond,
+//		RedactArgs:    true,
+//	})
+type Logger struct {
+	Logger        *slog.Logger  // Where to log; the default is slog.Default()
+	Level         slog.Leveler  // The level of regular queries; the default is debug
+	SlowThreshold time.Duration // Queries that take longer are logged as slow; 0 disables it
+	RedactArgs    bool          // Log the number of arguments instead of their values
+}
+
+// BeforeQuery implements QueryHook.
+func (l *Logger) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// AfterQuery implements QueryHook, logging the query.
+func (l *Logger) AfterQuery(ctx context.Context, event *QueryEvent) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := slog.LevelDebug
+	if l.Level != nil {
+		level = l.Level.Level()
+	}
+	msg := "query"
+	if event.Err != nil {
+		level, msg = slog.LevelError, "query failed"
+	} else if l.SlowThreshold > 0 && event.Duration >= l.SlowThreshold {
+		level, msg = slog.LevelWarn, "slow query"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("model", event.Model),
+		slog.String("op", string(event.Operation)),
+		slog.String("sql", event.SQL),
+	}
+	if l.RedactArgs {
+		attrs = append(attrs, slog.Int("args", len(event.Args)))
+	} else {
+		attrs = append(attrs, slog.Any("args", event.Args))
+	}
+	attrs = append(attrs, slog.Duration("duration", event.Duration), slog.Int64("rows", event.Rows))
+	if event.Err != nil {
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
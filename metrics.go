@@ -0,0 +1,145 @@
+package weasel
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives the metrics of the queries weasel runs, broken down by model (Model.Name)
+// and operation. Implement it to adapt weasel to your metrics system, and add it to the
+// connection with MetricsHook. ExpvarMetrics is the default implementation.
+type Metrics interface {
+	// CountQuery counts a query; err is the query's error, if it failed.
+	CountQuery(model string, op Operation, err error)
+	// ObserveLatency records how long a query took, in a histogram.
+	ObserveLatency(model string, op Operation, duration time.Duration)
+	// ObserveRows records the number of rows a query affected or returned, in a histogram.
+	ObserveRows(model string, op Operation, rows int64)
+}
+
+// MetricsHook returns a query hook that reports every query to metrics. For example:
+//
+//	conn.AddHook(weasel.MetricsHook(weasel.NewExpvarMetrics("weasel")))
+func MetricsHook(metrics Metrics) QueryHook {
+	return metricsHook{metrics: metrics}
+}
+
+type metricsHook struct {
+	metrics Metrics
+}
+
+func (h metricsHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (h metricsHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	h.metrics.CountQuery(event.Model, event.Operation, event.Err)
+	h.metrics.ObserveLatency(event.Model, event.Operation, event.Duration)
+	if event.Err == nil {
+		h.metrics.ObserveRows(event.Model, event.Operation, event.Rows)
+	}
+}
+
+// LatencyBuckets are the upper bounds of the latency histograms of ExpvarMetrics.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 5 * time.Second,
+}
+
+// RowBuckets are the upper bounds of the rows histograms of ExpvarMetrics.
+var RowBuckets = []int64{0, 1, 10, 100, 1000, 10000}
+
+// ExpvarMetrics publishes the metrics with expvar, so that they are served as JSON on
+// /debug/vars without any external service. Under its name, there is a map for every model
+// and operation, keyed "<model>.<operation>", with:
+//   - queries: the number of queries
+//   - errors: the number of queries that failed
+//   - latency: a histogram of the latency, with cumulative counts keyed by upper bound
+//     (for example "le_10ms" and "le_inf"), and the total in "sum_seconds"
+//   - rows: a histogram of the rows, keyed the same way ("le_100"), with the total in "sum"
+type ExpvarMetrics struct {
+	mu   sync.Mutex
+	root *expvar.Map
+}
+
+// expvarMu guards the lookup and publication of the maps of NewExpvarMetrics.
+var expvarMu sync.Mutex
+
+// NewExpvarMetrics creates the metrics and publishes them under name. If a map is already
+// published under name, for example by an earlier call, it is reused: the metrics keep counting
+// from its values, since expvar cannot unpublish a name. Like expvar.Publish, it panics if the
+// name is in use by another kind of variable.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if m, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarMetrics{root: m}
+	}
+	return &ExpvarMetrics{root: expvar.NewMap(name)}
+}
+
+// Map returns the published map, for example to read the metrics in tests.
+func (e *ExpvarMetrics) Map() *expvar.Map {
+	return e.root
+}
+
+// entry returns the map of the model and operation, creating it if needed.
+func (e *ExpvarMetrics) entry(model string, op Operation) *expvar.Map {
+	key := model + "." + string(op)
+	if v, ok := e.root.Get(key).(*expvar.Map); ok {
+		return v
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if v, ok := e.root.Get(key).(*expvar.Map); ok {
+		return v
+	}
+	m := new(expvar.Map).Init()
+	m.Set("queries", new(expvar.Int))
+	m.Set("errors", new(expvar.Int))
+	latency := new(expvar.Map).Init()
+	latency.Set("sum_seconds", new(expvar.Float))
+	m.Set("latency", latency)
+	rows := new(expvar.Map).Init()
+	rows.Set("sum", new(expvar.Int))
+	m.Set("rows", rows)
+	e.root.Set(key, m)
+	return m
+}
+
+// CountQuery implements Metrics.
+func (e *ExpvarMetrics) CountQuery(model string, op Operation, err error) {
+	m := e.entry(model, op)
+	m.Add("queries", 1)
+	if err != nil {
+		m.Add("errors", 1)
+	}
+}
+
+// ObserveLatency implements Metrics.
+func (e *ExpvarMetrics) ObserveLatency(model string, op Operation, duration time.Duration) {
+	latency := e.entry(model, op).Get("latency").(*expvar.Map)
+	for _, bound := range LatencyBuckets {
+		if duration <= bound {
+			latency.Add("le_"+bound.String(), 1)
+		}
+	}
+	latency.Add("le_inf", 1)
+	latency.AddFloat("sum_seconds", duration.Seconds())
+}
+
+// ObserveRows implements Metrics.
+func (e *ExpvarMetrics) ObserveRows(model string, op Operation, rows int64) {
+	hist := e.entry(model, op).Get("rows").(*expvar.Map)
+	for _, bound := range RowBuckets {
+		if rows <= bound {
+			hist.Add("le_"+strconv.FormatInt(bound, 10), 1)
+		}
+	}
+	hist.Add("le_inf", 1)
+	hist.Add("sum", rows)
+}
@@ -2,6 +2,8 @@ package weasel
 
 import (
 	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/carlmjohnson/truthy"
 )
@@ -22,102 +24,175 @@ type Field struct {
 
 // Type relation represents a relation's metadata, provided by struct tags.
 type Relation struct {
-	Name       string
-	Variant    string
-	Key        string
-	ForeignKey string
-	Table      string
-	Through    string
+	Name         string
+	Variant      string
+	Key          string
+	ForeignKey   string
+	Table        string
+	Through      string
+	Polymorphic  string
+	Dependent    string
+	CounterCache string
+}
+
+// TypeKey returns the type column of a polymorphic relation, for example commentable_type.
+// The column holds the table name of the related document.
+func (r Relation) TypeKey() string {
+	return r.Polymorphic + "_type"
 }
 
 // Model is the model itself. It extends Group and has all of Group's functionality, and more.
-// It also provides the table metadata to Group.
+// It also provides the table metadata to Group. Models are safe for concurrent use, but their
+// settings (DefaultScope, and those of the Init functions) should be set before.
 type Model[Doc DocumentBase] struct {
 	*Group[Doc]
-	Conn      Connection
-	tableName string
-	pk        string
-	fields    map[string]Field
-	relations map[string]Relation
-	ex        Doc
-	vals      map[string]any
+	Conn         Connection
+	tableName    string
+	pk           string
+	fields       map[string]Field
+	layout       *layout
+	relations    map[string]Relation
+	ex           Doc
+	vals         map[string]any
+	scopes       map[string]ScopeFunc[Doc]
+	defaultWhere whereable
+	defaultOrder string
+	tenant       string
+	cache        atomic.Pointer[resultCache]
+	mu           sync.RWMutex // Guards vals, scopes, the default scope, and the groups of the model and its groups
 }
 
 // Set sets a value on the model.
 func (m *Model[Doc]) Set(key string, val any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.vals[key] = val
 }
 
 // Get returns a value set by Set.
-func (m Model[Doc]) Get(key string) any {
+func (m *Model[Doc]) Get(key string) any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.vals[key]
 }
 
-// Relations returns the map of relations used internally by weasel.
-func (m Model[Doc]) Relations() map[string]Relation {
+// Scope registers a named, parameterized query that can be applied to the model or any of its
+// groups with Scoped. Unlike CreateGroup, a scope takes arguments and can change the query in any
+// way: add joins, orders and limits, not just where clauses. For example:
+//
+//	Person.Scope("InCountry", func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+//		return q.Joins("Place").Where(weasel.Eq{"place.country": args[0]})
+//	})
+//	Person.Scoped("InCountry", "Canada").All().Exec()
+func (m *Model[Doc]) Scope(name string, fn ScopeFunc[Doc]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scopes[name] = fn
+}
+
+// DefaultScope sets a condition and an order that every query of the model applies: the model
+// itself, its groups (including those made by CreateGroup and HasMany relations) and BelongsTo and
+// HasOne lookups. The condition's equality attributes are also set on created documents. Use
+// Unscoped to drop it for a query, and DefaultScope(nil, "") to remove it. For example:
+//
+//	Post.DefaultScope(weasel.Eq{"post.archived": false}, "post.published_at DESC")
+//	Post.Unscoped().All().Exec() // Includes archived posts
+func (m *Model[Doc]) DefaultScope(where whereable, order string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaultWhere = where
+	m.defaultOrder = order
+}
+
+// defaults returns the condition and order of the model's default scope. The order is the primary
+// key ascending if the scope has none, or if unscoped is set.
+func (m *Model[Doc]) defaults(unscoped bool) (whereable, string) {
+	if unscoped {
+		return nil, m.tableName + "." + m.pk + " ASC"
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.defaultOrder == "" {
+		return m.defaultWhere, m.tableName + "." + m.pk + " ASC"
+	}
+	return m.defaultWhere, m.defaultOrder
+}
+
+// Relations returns the map of relations used internally by weasel, keyed by field name.
+func (m *Model[Doc]) Relations() map[string]Relation {
 	return m.relations
 }
 
 // Name returns the table name of the model.
-func (m Model[Doc]) Name() string {
+func (m *Model[Doc]) Name() string {
 	return m.tableName
 }
 
 // Create creates a model from the given connection, document, table name, and initializers.
+// It panics if a relation has an unknown dependent action.
 func Create[Doc document[Doc]](conn Connection, ex Doc, name string, inits ...Init[Doc]) *Model[Doc] {
 	doc := ex
-	var pk string
+	var pk, tenant string
 	var relations = map[string]Relation{}
 	var fields = make(map[string]Field, 0)
 	t := reflect.Indirect(reflect.ValueOf(doc)).Type()
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		if name, ok := field.Tag.Lookup("db"); !ok {
+		if column, ok := field.Tag.Lookup("db"); !ok {
+			polymorphic := field.Tag.Get("polymorphic")
 			if belongsTo, bt := field.Tag.Lookup("belongsto"); bt {
 				fk := or(field.Tag.Get("fk"), "id")
-				key := or(field.Tag.Get("key"), belongsTo+"_id")
+				key := or(field.Tag.Get("key"), or(polymorphic, belongsTo)+"_id")
 				relation := Relation{
-					Name:       field.Name,
-					Table:      belongsTo,
-					ForeignKey: fk,
-					Key:        key,
-					Variant:    "belongsTo",
+					Name:         field.Name,
+					Table:        belongsTo,
+					ForeignKey:   fk,
+					Key:          key,
+					Variant:      "belongsTo",
+					Polymorphic:  polymorphic,
+					CounterCache: field.Tag.Get("countercache"),
 				}
-				relations["belongsTo"+belongsTo] = relation
+				relations[field.Name] = relation
 			} else if hasMany, hm := field.Tag.Lookup("hasmany"); hm {
-				foreignKey := or(field.Tag.Get("fk"), name+"_id")
+				foreignKey := or(field.Tag.Get("fk"), or(polymorphic, name)+"_id")
 				key := or(field.Tag.Get("key"), "id")
 				relation := Relation{
-					Name:       field.Name,
-					Table:      hasMany,
-					ForeignKey: foreignKey,
-					Variant:    "hasMany",
-					Key:        key,
+					Name:        field.Name,
+					Table:       hasMany,
+					ForeignKey:  foreignKey,
+					Variant:     "hasMany",
+					Key:         key,
+					Polymorphic: polymorphic,
+					Dependent:   field.Tag.Get("dependent"),
 				}
 				if through, hmt := field.Tag.Lookup("through"); hmt {
 					relation.Through = through
 					relation.Key = or(field.Tag.Get("key"), name+"_id")
 					relation.ForeignKey = or(field.Tag.Get("fk"), hasMany+"_id")
 				}
-				relations["hasMany"+hasMany] = relation
+				checkDependent(name, relation)
+				relations[field.Name] = relation
 			} else if hasOne, ho := field.Tag.Lookup("hasone"); ho {
-				foreignKey := or(field.Tag.Get("fk"), name+"_id")
+				foreignKey := or(field.Tag.Get("fk"), or(polymorphic, name)+"_id")
 				key := or(field.Tag.Get("key"), "id")
 				relation := Relation{
-					Name:       field.Name,
-					ForeignKey: foreignKey,
-					Table:      hasOne,
-					Variant:    "hasOne",
-					Key:        key,
+					Name:        field.Name,
+					ForeignKey:  foreignKey,
+					Table:       hasOne,
+					Variant:     "hasOne",
+					Key:         key,
+					Polymorphic: polymorphic,
+					Dependent:   field.Tag.Get("dependent"),
 				}
-				relations["hasOne"+hasOne] = relation
+				checkDependent(name, relation)
+				relations[field.Name] = relation
 			} else {
 				continue
 			}
 		} else {
 			f := Field{
 				Name:   field.Name,
-				DBName: name,
+				DBName: column,
 			}
 			if tp, to := field.Tag.Lookup("type"); !to {
 				f.Type = field.Type.Name()
@@ -127,12 +202,15 @@ func Create[Doc document[Doc]](conn Connection, ex Doc, name string, inits ...In
 			f.Default = field.Tag.Get("default")
 			_, f.NotNil = field.Tag.Lookup("notnil")
 			if _, isP := field.Tag.Lookup("pk"); isP {
-				pk = name
+				pk = column
 				f.PrimaryKey = true
 			} else {
 				f.PrimaryKey = false
 			}
-			fields[name] = f
+			if _, ok := field.Tag.Lookup("tenant"); ok {
+				tenant = column
+			}
+			fields[column] = f
 		}
 	}
 	model := &Model[Doc]{
@@ -140,23 +218,46 @@ func Create[Doc document[Doc]](conn Connection, ex Doc, name string, inits ...In
 		tableName: name,
 		pk:        pk,
 		fields:    fields,
+		layout:    newLayout(t, fields, conn),
 		ex:        doc,
 		relations: relations,
 		vals:      make(map[string]any),
+		scopes:    make(map[string]ScopeFunc[Doc]),
+		tenant:    tenant,
 	}
 	model.Group = &Group[Doc]{
 		Model:  model,
 		Where:  Eq{},
 		groups: make(map[string]*Group[Doc]),
-		order:  pk + " ASC",
 	}
 	doc.Create(doc, model)
+	if conn.registry != nil {
+		conn.registry.register(model)
+	}
 	for _, init := range inits {
 		init(model)
 	}
 	return model
 }
 
+func (m *Model[Doc]) findAny(owner DocumentBase, where any) (doc DocumentBase, err error) {
+	g, done := m.on(owner).operation("FindFor")
+	defer func() { finish(done, err, doc) }()
+	return g.findWhere(where)
+}
+
 func or[T any](vals ...T) T {
 	return truthy.First(vals...)
 }
+
+// columns returns the model's columns, qualified with its table name, in struct order. If only is
+// not nil, the other columns are left out.
+func (m *Model[Doc]) columns(only map[string]bool) []string {
+	columns := make([]string, 0, len(m.layout.order))
+	for _, c := range m.layout.order {
+		if only == nil || only[c] {
+			columns = append(columns, m.tableName+"."+c)
+		}
+	}
+	return columns
+}
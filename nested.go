@@ -0,0 +1,164 @@
+package weasel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Attach attaches documents to one of the document's relations, by field name, so that they are
+// written together with it. When the document is then created (with Model.Create or Group.Create)
+// or saved, the whole graph is persisted in one transaction, in dependency order: BelongsTo parents
+// first, then the document, then HasMany and HasOne children. Foreign keys are filled in from the
+// relation's key and fk. Attached documents that were never saved are created, the others are saved.
+//
+//	place := &PlaceSchema{Country: "Canada"}
+//	place.Attach("People", &PersonSchema{FirstName: "Jean"}, &PersonSchema{FirstName: "Marie"})
+//	place, err := Place.Create(place)
+func (d *Document[Doc]) Attach(relation string, docs ...DocumentBase) {
+	if d.attached == nil {
+		d.attached = make(map[string][]DocumentBase)
+	}
+	d.attached[relation] = append(d.attached[relation], docs...)
+}
+
+// persistence is implemented by every document through the embedded Document.
+type persistence interface {
+	isNew() bool
+	attachments() map[string][]DocumentBase
+	detach()
+	saveWith(conn Connection) error
+}
+
+func (d *Document[Doc]) isNew() bool {
+	return d.original == nil
+}
+
+func (d *Document[Doc]) attachments() map[string][]DocumentBase {
+	return d.attached
+}
+
+func (d *Document[Doc]) detach() {
+	d.attached = nil
+}
+
+func hasAttachments(doc any) bool {
+	p, ok := doc.(persistence)
+	return ok && len(p.attachments()) > 0
+}
+
+// attachedRelations returns the document's attached relation names that match the filter, sorted.
+func (m *Model[Doc]) attachedRelations(doc Doc, parents bool) ([]string, error) {
+	names := make([]string, 0)
+	for name := range any(doc).(persistence).attachments() {
+		rel, ok := m.relations[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no relation %s", m.tableName, name)
+		}
+		if (rel.Variant == "belongsTo") == parents {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// saveParents persists the BelongsTo documents attached to doc, and sets doc's keys to them.
+func (m *Model[Doc]) saveParents(conn Connection, doc Doc) error {
+	names, err := m.attachedRelations(doc, true)
+	if err != nil {
+		return err
+	}
+	attached := any(doc).(persistence).attachments()
+	for _, name := range names {
+		rel := m.relations[name]
+		for _, parent := range attached[name] {
+			saved, err := persist(conn, rel.Table, parent)
+			if err != nil {
+				return err
+			}
+			// doc may not be initialised yet when it is being created
+			m.layout.set(doc, rel.Key, saved.Get(rel.ForeignKey))
+			if rel.Polymorphic != "" {
+				m.layout.set(doc, rel.TypeKey(), saved.Table())
+			}
+		}
+	}
+	return nil
+}
+
+// saveChildren persists the HasMany and HasOne documents attached to doc, with their foreign
+// keys pointing to doc. For through relations, the join table rows are inserted.
+func (m *Model[Doc]) saveChildren(conn Connection, doc Doc, attached map[string][]DocumentBase) error {
+	names := make([]string, 0, len(attached))
+	for name := range attached {
+		if m.relations[name].Variant != "belongsTo" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rel := m.relations[name]
+		for _, child := range attached[name] {
+			if p, ok := child.(persistence); ok && p.isNew() {
+				if target, ok := conn.registry.lookup(rel.Table); ok {
+					target.initAny(child)
+				}
+			}
+			if rel.Through == "" {
+				child.Set(rel.ForeignKey, doc.Get(rel.Key))
+				if rel.Polymorphic != "" {
+					child.Set(rel.TypeKey(), m.tableName)
+				}
+			}
+			saved, err := persist(conn, rel.Table, child)
+			if err != nil {
+				return err
+			}
+			if rel.Through != "" {
+				_, err = conn.Builder.Insert(conn.Table(rel.Through)).
+					Columns(rel.Key, rel.ForeignKey).
+					Values(doc.Get(m.pk), saved.Get(saved.PrimaryKey())).
+					Exec()
+				conn.invalidate(rel.Table)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// persist creates the document in the model registered for table if it was never saved,
+// or validates and saves it otherwise. It returns the persisted document.
+func persist(conn Connection, table string, doc DocumentBase) (DocumentBase, error) {
+	target, ok := conn.registry.lookup(table)
+	if !ok {
+		return nil, fmt.Errorf("no model registered for table %q", table)
+	}
+	if p, ok := doc.(persistence); ok && !p.isNew() {
+		target.initAny(doc)
+		if len(doc.AllErrors()) > 0 {
+			return doc, errors.New("document is invalid")
+		}
+		return doc, p.saveWith(conn)
+	}
+	return target.createAny(conn, doc)
+}
+
+// initAny creates the document's internals for the model, so that Get and Set can be used on
+// a document that was built by hand.
+func (m *Model[Doc]) initAny(doc DocumentBase) {
+	if d, ok := doc.(Doc); ok {
+		callInit(d, m)
+	}
+}
+
+func (m *Model[Doc]) createAny(conn Connection, doc DocumentBase) (DocumentBase, error) {
+	d, ok := doc.(Doc)
+	if !ok {
+		return nil, fmt.Errorf("cannot create %T in %s", doc, m.tableName)
+	}
+	return m.Group.createWith(conn, d)
+}
@@ -1,9 +1,11 @@
 package weasel
 
 import (
+	"context"
 	"reflect"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 )
 
 // InsertQuery builds an insert sql query.
@@ -13,6 +15,7 @@ import (
 type InsertQuery[Doc DocumentBase] struct {
 	builder sq.InsertBuilder
 	model   *Model[Doc]
+	conn    Connection
 }
 
 func (i InsertQuery[Doc]) Columns(columns ...string) InsertQuery[Doc] {
@@ -31,10 +34,14 @@ func (i InsertQuery[Doc]) Values(values ...any) InsertQuery[Doc] {
 }
 
 func (i InsertQuery[Doc]) Exec() (Doc, error) {
-	ex := clone(i.model.ex, i.model)
+	ex := clone(i.model)
+	defer i.conn.onCommit(i.model.invalidate)
 	var id int64
-	if i.model.Conn.driver == "postgres" {
-		i.builder.Suffix("RETURNING id").QueryRow().Scan(&id)
+	if i.conn.driver == "postgres" {
+		i.conn.wrote()
+		if err := i.builder.Suffix("RETURNING " + i.model.pk).QueryRow().Scan(&id); err != nil {
+			return ex, err
+		}
 	} else {
 		res, err := i.builder.Exec()
 		if err != nil {
@@ -45,17 +52,30 @@ func (i InsertQuery[Doc]) Exec() (Doc, error) {
 			return ex, err
 		}
 	}
-	sql, args := i.model.Conn.Builder.Select("*").From(i.model.tableName).Where(Eq{i.model.pk: id}).MustSql()
-	err := i.model.Conn.DB.Get(ex, sql, args...)
+	sql, args := i.conn.Builder.Select(i.model.columns(nil)...).From(i.conn.Table(i.model.tableName)).Where(Eq{i.model.pk: id}).MustSql()
+	err := i.conn.observe(QueryEvent{Model: i.model.tableName, Operation: OpFind, SQL: sql, Args: args}, func(ctx context.Context) (int64, error) {
+		_, err := i.model.layout.get(ctx, i.conn.prepared(i.conn.ext()), ex, sql, args...)
+		return 1, err
+	})
+	if err == nil {
+		markClean(ex)
+		bind(ex, i.conn)
+		ex = track(i.conn, i.model, ex)
+	}
 	return ex, err
 }
 
 // Insert takes a model and builds an insert query. You can set the columns and values.
 // Finally, call Exec() to run the query.
 func Insert[Doc DocumentBase](model *Model[Doc]) InsertQuery[Doc] {
+	return insert(model.Conn, model)
+}
+
+func insert[Doc DocumentBase](conn Connection, model *Model[Doc]) InsertQuery[Doc] {
 	return InsertQuery[Doc]{
-		builder: model.Conn.Builder.Insert(model.tableName),
+		builder: conn.Instrument(model.tableName, OpInsert).Builder.Insert(conn.Table(model.tableName)),
 		model:   model,
+		conn:    conn,
 	}
 }
 
@@ -66,6 +86,10 @@ func Insert[Doc DocumentBase](model *Model[Doc]) InsertQuery[Doc] {
 type SelectQuery[Doc DocumentBase] struct {
 	builder sq.SelectBuilder
 	model   *Model[Doc]
+	conn    Connection
+	lock    lock
+	noCache bool
+	err     error
 }
 
 func (s SelectQuery[Doc]) Columns(columns ...string) SelectQuery[Doc] {
@@ -153,10 +177,118 @@ func (s SelectQuery[Doc]) Where(pred any, args ...any) SelectQuery[Doc] {
 	return s
 }
 
+// Joins inner joins the tables of the model's relations, by field name, using the relation's
+// keys. Through relations join both the join table and the related table. For example:
+//
+//	Person.All().Joins("Place").Where(weasel.Eq{"place.country": "US"})
+//
+// A relation pointing to the model's own table is aliased as <field>_<table>, for example friends_person.
+func (s SelectQuery[Doc]) Joins(relations ...string) SelectQuery[Doc] {
+	for _, name := range relations {
+		j, err := s.model.join(s.conn, name)
+		if err != nil {
+			s.err = err
+			return s
+		}
+		s.builder = j.apply(s.builder)
+	}
+	return s
+}
+
+// WhereHas keeps the documents that have at least one document in the relation, by field name,
+// compiled to an EXISTS subquery. The optional functions narrow the subquery, for example:
+//
+//	Person.All().WhereHas("Friends", func(q weasel.SelectBuilder) weasel.SelectBuilder {
+//		return q.Where(weasel.Eq{"friends_person.first_name": "Jane"})
+//	})
+func (s SelectQuery[Doc]) WhereHas(relation string, fns ...func(SelectBuilder) SelectBuilder) SelectQuery[Doc] {
+	return s.whereExists(false, relation, fns)
+}
+
+// WhereDoesntHave keeps the documents that have no document in the relation, compiled to a
+// NOT EXISTS subquery. See WhereHas.
+func (s SelectQuery[Doc]) WhereDoesntHave(relation string, fns ...func(SelectBuilder) SelectBuilder) SelectQuery[Doc] {
+	return s.whereExists(true, relation, fns)
+}
+
+func (s SelectQuery[Doc]) whereExists(not bool, relation string, fns []func(SelectBuilder) SelectBuilder) SelectQuery[Doc] {
+	j, err := s.model.join(s.conn, relation)
+	if err == nil {
+		var cond sq.Sqlizer
+		if cond, err = j.exists(not, fns); err == nil {
+			s.builder = s.builder.Where(cond)
+		}
+	}
+	if err != nil {
+		s.err = err
+	}
+	return s
+}
+
+// ForUpdate locks the selected rows against concurrent updates (FOR UPDATE) until the end of the
+// transaction; run the query in Connection.Transaction, or the lock is released as soon as the
+// statement ends. See Group.ForUpdate for an example.
+// Locking is supported on postgres and mysql; on other drivers Exec returns ErrLockUnsupported.
+func (s SelectQuery[Doc]) ForUpdate() SelectQuery[Doc] {
+	s.lock = s.lock.forUpdate()
+	return s
+}
+
+// ForShare takes a shared lock on the selected rows (FOR SHARE) until the end of the transaction.
+func (s SelectQuery[Doc]) ForShare() SelectQuery[Doc] {
+	s.lock = s.lock.forShare()
+	return s
+}
+
+// NoWait makes the query fail instead of waiting when a row is already locked.
+// If no lock strength was set, it implies ForUpdate.
+func (s SelectQuery[Doc]) NoWait() SelectQuery[Doc] {
+	s.lock = s.lock.noWait()
+	return s
+}
+
+// SkipLocked skips rows that are already locked instead of waiting for them.
+// If no lock strength was set, it implies ForUpdate.
+func (s SelectQuery[Doc]) SkipLocked() SelectQuery[Doc] {
+	s.lock = s.lock.skipLocked()
+	return s
+}
+
+// NoCache makes the query bypass the model's result cache. See Model.Cache.
+func (s SelectQuery[Doc]) NoCache() SelectQuery[Doc] {
+	s.noCache = true
+	return s
+}
+
 func (s SelectQuery[Doc]) Exec() (Doc, error) {
-	sql, args := s.builder.MustSql()
-	ex := clone(s.model.ex, s.model)
-	err := s.model.Conn.DB.Get(ex, sql, args...)
+	ex := clone(s.model)
+	if s.err != nil {
+		return ex, s.err
+	}
+	builder, err := s.lock.apply(s.builder, s.conn.driver)
+	if err != nil {
+		return ex, err
+	}
+	sql, args := builder.MustSql()
+	key := s.model.cacheKey(s.conn, s.noCache, s.lock, "one", sql, args)
+	if r, indexes, ok := s.model.cached(key); ok {
+		s.model.restore(ex, r, indexes, 0)
+	} else {
+		var columns []string
+		event := QueryEvent{Model: s.model.tableName, Operation: OpFind, SQL: sql, Args: args}
+		err = s.conn.read(event, s.lock.locking(), func(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+			columns, err = s.model.layout.get(ctx, db, ex, sql, args...)
+			return 1, err
+		})
+		if err == nil {
+			s.model.store(key, columns, []Doc{ex})
+		}
+	}
+	if err == nil {
+		markClean(ex)
+		bind(ex, s.conn)
+		ex = track(s.conn, s.model, ex)
+	}
 	return ex, err
 }
 
@@ -164,9 +296,14 @@ func (s SelectQuery[Doc]) Exec() (Doc, error) {
 // You can pass the columns and model. When you are done building the query,
 // call the Exec() function to run it.
 func Select[Doc DocumentBase](columns []string, model *Model[Doc]) SelectQuery[Doc] {
+	return selectOne(model.Conn, columns, model)
+}
+
+func selectOne[Doc DocumentBase](conn Connection, columns []string, model *Model[Doc]) SelectQuery[Doc] {
 	return SelectQuery[Doc]{
-		builder: model.Conn.Builder.Select(columns...).From(model.tableName),
+		builder: conn.Builder.Select(columns...).From(conn.Table(model.tableName)),
 		model:   model,
+		conn:    conn,
 	}
 }
 
@@ -175,8 +312,15 @@ func Select[Doc DocumentBase](columns []string, model *Model[Doc]) SelectQuery[D
 // Note: SelectManyQuery does not include all of squirrel's functions.
 // It handles some of the internally.
 type SelectManyQuery[Doc DocumentBase] struct {
-	builder sq.SelectBuilder
-	model   *Model[Doc]
+	builder  sq.SelectBuilder
+	model    *Model[Doc]
+	conn     Connection
+	orders   []string
+	windowed bool
+	lock     lock
+	noCache  bool
+	relation string // The relation field the query loads, if any; see OperationEvent
+	err      error
 }
 
 func (s SelectManyQuery[Doc]) Columns(columns ...string) SelectManyQuery[Doc] {
@@ -231,11 +375,13 @@ func (s SelectManyQuery[Doc]) LeftJoin(join string, rest ...any) SelectManyQuery
 
 func (s SelectManyQuery[Doc]) Limit(limit uint64) SelectManyQuery[Doc] {
 	s.builder = s.builder.Limit(limit)
+	s.windowed = true
 	return s
 }
 
 func (s SelectManyQuery[Doc]) Offset(offset uint64) SelectManyQuery[Doc] {
 	s.builder = s.builder.Offset(offset)
+	s.windowed = true
 	return s
 }
 
@@ -244,8 +390,10 @@ func (s SelectManyQuery[Doc]) Options(options ...string) SelectManyQuery[Doc] {
 	return s
 }
 
+// OrderBy adds ORDER BY expressions to the query. They are kept apart from the builder until the
+// query is run, so that the Nth family can invert them (see Group.NthToLast).
 func (s SelectManyQuery[Doc]) OrderBy(orderBys ...string) SelectManyQuery[Doc] {
-	s.builder = s.builder.OrderBy(orderBys...)
+	s.orders = append(s.orders[:len(s.orders):len(s.orders)], orderBys...)
 	return s
 }
 
@@ -264,30 +412,175 @@ func (s SelectManyQuery[Doc]) Where(pred any, args ...any) SelectManyQuery[Doc]
 	return s
 }
 
-func (s SelectManyQuery[Doc]) Exec() ([]Doc, error) {
-	sql, args := s.builder.MustSql()
-	p := clone(s.model.ex, s.model)
+// Joins inner joins the tables of the model's relations, by field name, using the relation's
+// keys. Through relations join both the join table and the related table. For example:
+//
+//	Person.All().Joins("Place").Where(weasel.Eq{"place.country": "US"})
+//
+// A relation pointing to the model's own table is aliased as <field>_<table>, for example friends_person.
+func (s SelectManyQuery[Doc]) Joins(relations ...string) SelectManyQuery[Doc] {
+	for _, name := range relations {
+		j, err := s.model.join(s.conn, name)
+		if err != nil {
+			s.err = err
+			return s
+		}
+		s.builder = j.apply(s.builder)
+	}
+	return s
+}
+
+// WhereHas keeps the documents that have at least one document in the relation, by field name,
+// compiled to an EXISTS subquery. The optional functions narrow the subquery, for example:
+//
+//	Person.All().WhereHas("Friends", func(q weasel.SelectBuilder) weasel.SelectBuilder {
+//		return q.Where(weasel.Eq{"friends_person.first_name": "Jane"})
+//	})
+func (s SelectManyQuery[Doc]) WhereHas(relation string, fns ...func(SelectBuilder) SelectBuilder) SelectManyQuery[Doc] {
+	return s.whereExists(false, relation, fns)
+}
+
+// WhereDoesntHave keeps the documents that have no document in the relation, compiled to a
+// NOT EXISTS subquery. See WhereHas.
+func (s SelectManyQuery[Doc]) WhereDoesntHave(relation string, fns ...func(SelectBuilder) SelectBuilder) SelectManyQuery[Doc] {
+	return s.whereExists(true, relation, fns)
+}
+
+func (s SelectManyQuery[Doc]) whereExists(not bool, relation string, fns []func(SelectBuilder) SelectBuilder) SelectManyQuery[Doc] {
+	j, err := s.model.join(s.conn, relation)
+	if err == nil {
+		var cond sq.Sqlizer
+		if cond, err = j.exists(not, fns); err == nil {
+			s.builder = s.builder.Where(cond)
+		}
+	}
+	if err != nil {
+		s.err = err
+	}
+	return s
+}
+
+// ForUpdate locks the selected rows against concurrent updates (FOR UPDATE) until the end of the
+// transaction; run the query in Connection.Transaction, or the lock is released as soon as the
+// statement ends. See Group.ForUpdate for an example.
+// Locking is supported on postgres and mysql; on other drivers Exec returns ErrLockUnsupported.
+func (s SelectManyQuery[Doc]) ForUpdate() SelectManyQuery[Doc] {
+	s.lock = s.lock.forUpdate()
+	return s
+}
+
+// ForShare takes a shared lock on the selected rows (FOR SHARE) until the end of the transaction.
+func (s SelectManyQuery[Doc]) ForShare() SelectManyQuery[Doc] {
+	s.lock = s.lock.forShare()
+	return s
+}
+
+// NoWait makes the query fail instead of waiting when a row is already locked.
+// If no lock strength was set, it implies ForUpdate.
+func (s SelectManyQuery[Doc]) NoWait() SelectManyQuery[Doc] {
+	s.lock = s.lock.noWait()
+	return s
+}
+
+// SkipLocked skips rows that are already locked instead of waiting for them.
+// If no lock strength was set, it implies ForUpdate.
+func (s SelectManyQuery[Doc]) SkipLocked() SelectManyQuery[Doc] {
+	s.lock = s.lock.skipLocked()
+	return s
+}
+
+// NoCache makes the query bypass the model's result cache. See Model.Cache.
+func (s SelectManyQuery[Doc]) NoCache() SelectManyQuery[Doc] {
+	s.noCache = true
+	return s
+}
+
+func (s SelectManyQuery[Doc]) Exec() (docs []Doc, err error) {
+	var done func(err error)
+	s.conn, done = s.conn.operation(OperationEvent{Model: s.model.tableName, Name: "All", Relation: s.relation})
+	defer func() { finish(done, err, docs...) }()
+	return s.exec()
+}
+
+func (s SelectManyQuery[Doc]) exec() ([]Doc, error) {
+	p := clone(s.model)
 	ex := []Doc{p}
-	err := s.model.Conn.DB.Select(&ex, sql, args...)
-	for _, d := range ex {
+	if s.err != nil {
+		return ex, s.err
+	}
+	builder, err := s.lock.apply(s.ordered(), s.conn.driver)
+	if err != nil {
+		return ex, err
+	}
+	sql, args := builder.MustSql()
+	key := s.model.cacheKey(s.conn, s.noCache, s.lock, "all", sql, args)
+	if r, indexes, ok := s.model.cached(key); ok {
+		ex = make([]Doc, len(r.Rows))
+		for i := range r.Rows {
+			ex[i] = reflect.New(s.model.layout.typ).Interface().(Doc)
+			s.model.restore(ex[i], r, indexes, i)
+		}
+	} else {
+		var columns []string
+		event := QueryEvent{Model: s.model.tableName, Operation: OpAll, SQL: sql, Args: args}
+		err = s.conn.read(event, s.lock.locking(), func(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+			columns, err = selectDocs(ctx, db, s.model, &ex, sql, args...)
+			return int64(len(ex)), err
+		})
+		if err == nil {
+			s.model.store(key, columns, ex)
+		}
+	}
+	if err != nil {
+		return ex, err
+	}
+	for i, d := range ex {
+		bind(d, s.conn)
 		callInit(d, s.model)
+		markClean(d)
+		ex[i] = track(s.conn, s.model, d)
+	}
+	return ex, nil
+}
+
+// ordered returns the query's builder with its ORDER BY expressions.
+func (s SelectManyQuery[Doc]) ordered() sq.SelectBuilder {
+	if len(s.orders) == 0 {
+		return s.builder
+	}
+	return s.builder.OrderBy(s.orders...)
+}
+
+// one turns the query into a query of a single document.
+func (s SelectManyQuery[Doc]) one() SelectQuery[Doc] {
+	return SelectQuery[Doc]{
+		builder: s.ordered(),
+		model:   s.model,
+		conn:    s.conn,
+		lock:    s.lock,
+		noCache: s.noCache,
+		err:     s.err,
 	}
-	return ex, err
 }
 
 // SelectMany builds a select query. Pass in the columns and model,
 // and when you are done building the query, call the Exec() function
 // to run it.
 func SelectMany[Doc DocumentBase](columns []string, model *Model[Doc]) SelectManyQuery[Doc] {
+	return selectMany(model.Conn, columns, model)
+}
+
+func selectMany[Doc DocumentBase](conn Connection, columns []string, model *Model[Doc]) SelectManyQuery[Doc] {
 	return SelectManyQuery[Doc]{
-		builder: model.Conn.Builder.Select(columns...).From(model.tableName),
+		builder: conn.Builder.Select(columns...).From(conn.Table(model.tableName)),
 		model:   model,
+		conn:    conn,
 	}
 }
 
-func clone[Doc DocumentBase](d Doc, m *Model[Doc]) Doc {
-	t := reflect.Indirect(reflect.ValueOf(d)).Type()
-	v := reflect.New(t)
-	v.MethodByName("Create").Call([]reflect.Value{v, reflect.ValueOf(m)})
-	return v.Interface().(Doc)
+// clone returns a new, empty document of the model.
+func clone[Doc DocumentBase](m *Model[Doc]) Doc {
+	d := reflect.New(m.layout.typ).Interface().(Doc)
+	any(d).(creator[Doc]).Create(d, m)
+	return d
 }
@@ -0,0 +1,113 @@
+package weasel
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registered is implemented by every model, whatever its document type, so that the
+// registry can work with models it only knows by table name.
+type registered interface {
+	Name() string
+	Relations() map[string]Relation
+	findAny(owner DocumentBase, where any) (DocumentBase, error)
+	relate(rel Relation, owner DocumentBase) any
+	relationType(variant string) reflect.Type
+	fieldType(name string) reflect.Type
+	destroyWhere(conn Connection, where any) error
+	createAny(conn Connection, doc DocumentBase) (DocumentBase, error)
+	initAny(doc DocumentBase)
+	primaryKey() string
+	tenantWhere(conn Connection) (Eq, error)
+	tenantWhereAs(conn Connection, alias string) (Eq, error)
+	invalidate()
+}
+
+// Registry holds the models created on a connection, keyed by table name. Models register
+// into their connection's registry in Create. Every document's BelongsTo, HasMany and HasOne
+// fields are then wired automatically to the registered model of their table, so calling
+// use.BelongsTo and the like in Init is optional. Call Verify once all of the models are
+// created to check that every relation can be resolved.
+type Registry struct {
+	mu     sync.RWMutex
+	models map[string]registered
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{models: make(map[string]registered)}
+}
+
+func (r *Registry) register(m registered) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models[m.Name()] = m
+}
+
+func (r *Registry) lookup(table string) (registered, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.models[table]
+	return m, ok
+}
+
+// Models returns the table names of the registered models, sorted.
+func (r *Registry) Models() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.models))
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find finds a document in the model registered for the table. You may need to use type
+// assertion on the result. It returns an error if no model is registered for the table.
+func (r *Registry) Find(table string, where any) (DocumentBase, error) {
+	return r.FindFor(nil, table, where)
+}
+
+// FindFor is like Find, but runs on the connection of owner (see Document.Conn), so that the
+// lookup follows its tenant.
+func (r *Registry) FindFor(owner DocumentBase, table string, where any) (DocumentBase, error) {
+	m, ok := r.lookup(table)
+	if !ok {
+		return nil, fmt.Errorf("no model registered for table %q", table)
+	}
+	return m.findAny(owner, where)
+}
+
+// Verify checks that every relation of the registered models points to a registered model,
+// whose document type matches the relation field. Call it at startup, after all of the models
+// are created, for example:
+//
+//	if err := conn.Registry().Verify(); err != nil {
+//		log.Fatal(err)
+//	}
+func (r *Registry) Verify() error {
+	problems := make([]string, 0)
+	for _, name := range r.Models() {
+		m, _ := r.lookup(name)
+		for _, rel := range m.Relations() {
+			if rel.Polymorphic != "" && rel.Variant == "belongsTo" {
+				continue
+			}
+			target, ok := r.lookup(rel.Table)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: no model registered for table %q", name, rel.Name, rel.Table))
+			} else if want, got := m.fieldType(rel.Name), target.relationType(rel.Variant); want != got {
+				problems = append(problems, fmt.Sprintf("%s.%s: field is %v, but model %q provides %v", name, rel.Name, want, rel.Table, got))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("unresolved relations: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -1,23 +1,114 @@
 package weasel
 
+import (
+	"fmt"
+	"reflect"
+)
+
 // Type HasMany is the type used to represent a one-to-many or many-to-many relationship in a schema.
 // Use the following struct tags to give it more information:
 //   - key: default is the primary key. This is the column that the foreign key points to.
 //   - fk: the name of the foreign key column.
 //   - through: the join table for many-to-many relationships.
+//   - polymorphic: the name of a polymorphic relation on the other table, for example commentable.
+//   - dependent: what Delete does with the related documents: destroy, delete, nullify or restrict.
 //   - hasmany: the table that it has many of.
-type HasMany[Doc document[Doc]] func() *Group[Doc]
+type HasMany[Doc DocumentBase] func() *Group[Doc]
 
 // Type BelongsTo is the type used to represent the flipside of a one-to-many relationship in a schema.
 // Use the following struct tags to give it more information:
 //   - key: default is the primary key. This is the column that the foreign key points to.
 //   - fk: the name of the foreign key column.
+//   - countercache: a column of the other table that counts its documents, kept up to date by
+//     Create, Delete and Save. See Model.ResetCounters.
 //   - belongsto: the table that it belongs to.
-type BelongsTo[Doc document[Doc]] func() (Doc, error)
+type BelongsTo[Doc DocumentBase] func() (Doc, error)
+
+// Type BelongsToAny is the type used to represent the flipside of a polymorphic relationship,
+// where the document can belong to documents of different tables. You may need to use type
+// assertion on the result. Use the following struct tags to give it more information:
+//   - belongsto: leave empty, the table is read from the type column.
+//   - polymorphic: the name of the relation, for example commentable. The document must have
+//     the commentable_id and commentable_type columns.
+//   - key: default is <polymorphic>_id. This is the column holding the related document's key.
+//   - fk: default is id. This is the column that the key points to.
+type BelongsToAny func() (DocumentBase, error)
 
 // Type HasMany is the type used to represent a one-to-one relationship in a schema.
 // Use the following struct tags to give it more information:
 //   - key: default is the primary key. This is the column that the foreign key points to.
 //   - fk: the name of the foreign key column.
+//   - polymorphic: the name of a polymorphic relation on the other table, for example commentable.
+//   - dependent: what Delete does with the related document: destroy, delete, nullify or restrict.
 //   - hasone: the table that it has one of.
-type HasOne[Doc document[Doc]] func() (Doc, error)
+type HasOne[Doc DocumentBase] func() (Doc, error)
+
+// relate returns the function that loads the documents of m related to owner through rel.
+// Its type is HasMany[Doc], BelongsTo[Doc] or HasOne[Doc], depending on the relation's variant.
+func (m *Model[Doc]) relate(rel Relation, owner DocumentBase) any {
+	switch rel.Variant {
+	case "hasMany":
+		var fn HasMany[Doc] = func() *Group[Doc] {
+			return NewRelationGroup(m, rel, owner)
+		}
+		return fn
+	case "belongsTo":
+		var fn BelongsTo[Doc] = func() (Doc, error) {
+			return m.on(owner).findWhere(Eq{m.tableName + "." + rel.ForeignKey: owner.Get(rel.Key)})
+		}
+		return fn
+	case "hasOne":
+		var fn HasOne[Doc] = func() (Doc, error) {
+			where := Eq{m.tableName + "." + rel.ForeignKey: owner.Get(rel.Key)}
+			if rel.Polymorphic != "" {
+				where[m.tableName+"."+rel.TypeKey()] = owner.Table()
+			}
+			return m.on(owner).findWhere(where)
+		}
+		return fn
+	}
+	return nil
+}
+
+// relationType returns the type of the functions returned by relate for the variant.
+func (m *Model[Doc]) relationType(variant string) reflect.Type {
+	return reflect.TypeOf(m.relate(Relation{Variant: variant}, nil))
+}
+
+// fieldType returns the type of the schema's field with the given name.
+func (m *Model[Doc]) fieldType(name string) reflect.Type {
+	if index, ok := m.layout.names[name]; ok {
+		return m.layout.typ.FieldByIndex(index).Type
+	}
+	return nil
+}
+
+// wire fills the relation fields of the document with the models registered on the
+// connection. Relations whose table has no registered model are left alone; see Registry.Verify.
+func (m *Model[Doc]) wire(doc Doc) {
+	if m.Conn.registry == nil {
+		return
+	}
+	v := reflect.Indirect(reflect.ValueOf(doc))
+	for _, rel := range m.relations {
+		f := v.FieldByIndex(m.layout.names[rel.Name])
+		var fn reflect.Value
+		if rel.Polymorphic != "" && rel.Variant == "belongsTo" {
+			fn = reflect.ValueOf(belongsToAny(m.Conn.registry, rel, doc))
+		} else if target, ok := m.Conn.registry.lookup(rel.Table); ok {
+			fn = reflect.ValueOf(target.relate(rel, doc))
+		} else {
+			continue
+		}
+		if fn.Type() == f.Type() {
+			f.Set(fn)
+		}
+	}
+}
+
+func belongsToAny(registry *Registry, rel Relation, owner DocumentBase) BelongsToAny {
+	return func() (DocumentBase, error) {
+		table := fmt.Sprint(owner.Get(rel.TypeKey()))
+		return registry.FindFor(owner, table, Eq{table + "." + rel.ForeignKey: owner.Get(rel.Key)})
+	}
+}
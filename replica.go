@@ -0,0 +1,185 @@
+package weasel
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// replicaRetry is how long a replica that failed is skipped before it is tried again.
+const replicaRetry = 10 * time.Second
+
+// replicaLag is how long a write is assumed to take to reach the replicas when the sticky window
+// is shorter. Reads from a replica within it are not cached.
+const replicaLag = time.Second
+
+// replicas holds the read replicas of a connection, shared by all of its copies.
+type replicas struct {
+	dbs       []*replica
+	next      uint32
+	sticky    int64 // time.Duration
+	lastWrite int64 // Unix nanoseconds
+}
+
+type replica struct {
+	db   *sqlx.DB
+	down int64 // Unix nanoseconds until which the replica is skipped
+}
+
+func connectReplicas(driver string, opts []Opts) *replicas {
+	if len(opts) == 0 {
+		return nil
+	}
+	r := &replicas{}
+	for _, o := range opts {
+		o.setDefaults(driver)
+		dsn, err := o.toDSN(driver)
+		if err != nil {
+			panic(err)
+		}
+		rep := &replica{db: sqlx.MustOpen(driver, dsn)}
+		if err := rep.db.Ping(); err != nil {
+			rep.fail()
+		}
+		r.dbs = append(r.dbs, rep)
+	}
+	return r
+}
+
+// pick returns the next healthy replica, round-robin, or nil if none is healthy.
+func (r *replicas) pick() *sqlx.DB {
+	now := time.Now().UnixNano()
+	for range r.dbs {
+		rep := r.dbs[int(atomic.AddUint32(&r.next, 1)-1)%len(r.dbs)]
+		if atomic.LoadInt64(&rep.down) <= now {
+			return rep.db
+		}
+	}
+	return nil
+}
+
+// failed marks the replica behind db as unhealthy.
+func (r *replicas) failed(db *sqlx.DB) {
+	for _, rep := range r.dbs {
+		if rep.db == db {
+			rep.fail()
+		}
+	}
+}
+
+func (rep *replica) fail() {
+	atomic.StoreInt64(&rep.down, time.Now().Add(replicaRetry).UnixNano())
+}
+
+// wrote records a write, which starts the sticky window.
+func (r *replicas) wrote() {
+	atomic.StoreInt64(&r.lastWrite, time.Now().UnixNano())
+}
+
+// sticking checks if the last write is recent enough that reads should stay on the primary.
+func (r *replicas) sticking() bool {
+	sticky := atomic.LoadInt64(&r.sticky)
+	return sticky > 0 && time.Now().UnixNano()-atomic.LoadInt64(&r.lastWrite) < sticky
+}
+
+// lagging checks if the last write is recent enough that the replicas may not have it yet.
+func (r *replicas) lagging() bool {
+	window := max(time.Duration(atomic.LoadInt64(&r.sticky)), replicaLag)
+	return time.Now().UnixNano()-atomic.LoadInt64(&r.lastWrite) < int64(window)
+}
+
+// SetStickyWindow sets how long reads keep going to the primary after a write, so that they see
+// it before it reaches the replicas. Reads from a replica are not cached by the result cache
+// (see Model.Cache) within the window, or within a second if it is shorter. It applies to every
+// copy of the connection; the default is 0.
+func (c Connection) SetStickyWindow(d time.Duration) {
+	if c.replicas != nil {
+		atomic.StoreInt64(&c.replicas.sticky, int64(d))
+	}
+}
+
+// UsePrimary returns a copy of the connection whose reads go to the primary, never to a replica.
+func (c Connection) UsePrimary() Connection {
+	c.primary = true
+	return c
+}
+
+// UsePrimary returns a copy of the group whose reads go to the primary. See Connection.UsePrimary.
+func (m Group[Doc]) UsePrimary() *Group[Doc] {
+	return m.Using(m.connection().UsePrimary())
+}
+
+// reader returns what reads should run on: the transaction if there is one; the primary for
+// locking reads, with UsePrimary, or during the sticky window; or else a healthy replica.
+func (c Connection) reader(locking bool) sqlx.ExtContext {
+	if c.tx != nil {
+		return c.tx
+	}
+	if c.replicas == nil || c.primary || locking || c.replicas.sticking() {
+		return c.DB
+	}
+	if db := c.replicas.pick(); db != nil {
+		return db
+	}
+	return c.DB
+}
+
+// lagging checks if the connection's reads go to a replica that may not have the last write yet,
+// so that their results should not be cached.
+func (c Connection) lagging() bool {
+	return c.tx == nil && c.replicas != nil && !c.primary && !c.replicas.sticking() && c.replicas.lagging()
+}
+
+// read runs the query of the event with fn on the connection's reader, reporting it to the query
+// hooks. fn returns the number of rows read. If a replica fails with a connection error, it is
+// marked unhealthy and fn runs again on the primary.
+func (c Connection) read(event QueryEvent, locking bool, fn func(ctx context.Context, db sqlx.ExtContext) (int64, error)) error {
+	return c.observe(event, func(ctx context.Context) (int64, error) {
+		ext := c.reader(locking)
+		rows, err := fn(ctx, c.prepared(ext))
+		if db, ok := ext.(*sqlx.DB); ok && db != c.DB && isConnError(err) {
+			c.replicas.failed(db)
+			return fn(ctx, c.prepared(c.DB))
+		}
+		return rows, err
+	})
+}
+
+// isConnError checks if err means that the database could not be reached.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return err != nil && (errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr))
+}
+
+// writer runs the statements of the connection's Builder on the primary, and records them as
+// writes for the sticky window.
+type writer struct {
+	*sqlx.DB
+	replicas *replicas
+}
+
+func (w writer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	w.replicas.wrote()
+	return w.DB.ExecContext(ctx, query, args...)
+}
+
+// runner returns what the connection's Builder runs on outside of transactions.
+func (c Connection) runner() sqlx.ExtContext {
+	if c.replicas != nil {
+		return writer{DB: c.DB, replicas: c.replicas}
+	}
+	return c.DB
+}
+
+// wrote records a write on the connection, for statements that do not go through Exec.
+func (c Connection) wrote() {
+	if c.replicas != nil {
+		c.replicas.wrote()
+	}
+}
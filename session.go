@@ -0,0 +1,220 @@
+package weasel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Session is a unit of work with an identity map. While a context carries a session (see
+// ContextWithSession), the documents loaded or created with that context are kept in the session
+// by model and primary key, so that loading the same document again, through any query or
+// relation, returns the same pointer instead of a copy that goes out of sync. Flush then saves all
+// of the session's changed documents in one transaction. For example:
+//
+//	s := weasel.NewSession()
+//	ctx := weasel.ContextWithSession(r.Context(), s)
+//	p, _ := Person.WithContext(ctx).Find(1)
+//	place, _ := Place.WithContext(ctx).Find(1)
+//	people, _ := place.People().All().Exec() // people[0] == p, if p is in place 1
+//	p.Email = "john@doe.com"
+//	err := s.Flush()
+//
+// Documents already in the session are returned as they are, with their unsaved changes; use
+// Reload to read them again. If the document in the session was partially loaded (see
+// Group.Select), the columns it skipped are filled from the new load. Documents of different
+// tenants or schemas are kept apart. A session is safe for concurrent use, but its documents are
+// not.
+type Session struct {
+	mu   sync.Mutex
+	docs map[sessionKey]DocumentBase
+	keys []sessionKey // In the order the documents entered the session
+}
+
+type sessionKey struct {
+	model  any
+	table  string // Qualified with the tenant's schema in schema mode
+	tenant string
+	id     string
+}
+
+type sessionCtxKey struct{}
+
+// NewSession creates an empty session.
+func NewSession() *Session {
+	return &Session{docs: make(map[sessionKey]DocumentBase)}
+}
+
+// ContextWithSession returns a copy of ctx that carries the session. Pass it to
+// Connection.WithContext or Group.WithContext to load documents into the session.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, s)
+}
+
+// SessionFromContext returns the session carried by ctx, if any.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionCtxKey{}).(*Session)
+	return s, ok
+}
+
+// Len returns the number of documents in the session.
+func (s *Session) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.docs)
+}
+
+// Clear removes all of the documents from the session, without saving them.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = make(map[sessionKey]DocumentBase)
+	s.keys = nil
+}
+
+// Flush saves the documents of the session that changed (or have attached documents) in one
+// transaction, in the order they entered the session. If a save fails, the transaction is rolled
+// back and the documents keep their changes, so that Flush can be called again.
+func (s *Session) Flush() error {
+	docs := s.changed()
+	if len(docs) == 0 {
+		return nil
+	}
+	restores := make([]func(), 0, len(docs))
+	for _, doc := range docs {
+		restores = append(restores, doc.(snapshotter).snapshot())
+	}
+	err := docs[0].Conn().Transaction(func(tx Connection) error {
+		for _, doc := range docs {
+			if len(doc.AllErrors()) > 0 {
+				return fmt.Errorf("document %s %v is invalid", doc.Table(), doc.Get(doc.PrimaryKey()))
+			}
+			conn := doc.Conn()
+			if conn.DB != tx.DB {
+				return errors.New("session documents are on different databases")
+			}
+			conn.tx, conn.txState = tx.tx, tx.txState
+			conn.rewire()
+			if err := doc.(persistence).saveWith(conn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+	return err
+}
+
+// changed returns the documents that Flush saves.
+func (s *Session) changed() []DocumentBase {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make([]DocumentBase, 0)
+	for _, key := range s.keys {
+		doc, ok := s.docs[key]
+		if ok && (doc.IsDirty() || hasAttachments(doc)) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+func (s *Session) key(conn Connection, model any, doc DocumentBase) sessionKey {
+	key := sessionKey{model: model, table: conn.Table(doc.Table()), id: fmt.Sprint(doc.Get(doc.PrimaryKey()))}
+	if conn.allTenants {
+		key.tenant = "*"
+	} else if conn.hasTenant {
+		key.tenant = fmt.Sprint(conn.tenant)
+	}
+	return key
+}
+
+// track returns the document of the session of conn's context with the same model, tenant and
+// primary key as doc, adding doc to the session if there is none. The columns that the document
+// in the session did not load are merged from doc.
+func track[Doc DocumentBase](conn Connection, m *Model[Doc], doc Doc) Doc {
+	s, ok := SessionFromContext(conn.Context())
+	if !ok {
+		return doc
+	}
+	key := s.key(conn, m, doc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.docs[key].(Doc); ok {
+		any(existing).(merger).merge(doc)
+		return existing
+	}
+	s.docs[key] = doc
+	s.keys = append(s.keys, key)
+	return doc
+}
+
+// untrack removes the deleted document from the session of conn's context.
+func untrack[Doc DocumentBase](conn Connection, m *Model[Doc], doc Doc) {
+	s, ok := SessionFromContext(conn.Context())
+	if !ok {
+		return
+	}
+	key := s.key(conn, m, doc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.docs[key]; !ok {
+		return
+	}
+	delete(s.docs, key)
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i:i], s.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// merger is implemented by every document through the embedded Document.
+type merger interface {
+	merge(from DocumentBase)
+	partial() map[string]bool
+}
+
+func (d *Document[Doc]) partial() map[string]bool {
+	return d.unloaded
+}
+
+// merge fills the fields that a partial load skipped with the values of from, another load of
+// the same document, if it loaded them. Fields that were set since are left alone.
+func (d *Document[Doc]) merge(from DocumentBase) {
+	if len(d.unloaded) == 0 {
+		return
+	}
+	skipped := from.(merger).partial()
+	unloaded := make(map[string]bool)
+	for f := range d.unloaded {
+		if skipped[f] || d.IsLoaded(f) {
+			unloaded[f] = true
+			continue
+		}
+		v := from.Get(f)
+		d.set(f, v)
+		d.original[f] = v
+	}
+	d.unloaded = unloaded
+}
+
+// snapshotter is implemented by every document through the embedded Document.
+type snapshotter interface {
+	snapshot() func()
+}
+
+// snapshot returns a function that restores the document's persisted state and attachments to
+// what they are now, for example after a failed transaction.
+func (d *Document[Doc]) snapshot() func() {
+	original, unloaded, assigned, attached := d.original, d.unloaded, d.assigned, d.attached
+	return func() {
+		d.original, d.unloaded, d.assigned, d.attached = original, unloaded, assigned, attached
+	}
+}
@@ -0,0 +1,219 @@
+package weasel
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// stmtCache is a least recently used cache of prepared statements, keyed by database and SQL,
+// shared by all of the copies of a connection. It is disabled while its size is 0.
+type stmtCache struct {
+	size  int64
+	mu    sync.Mutex
+	lru   *list.List // Of *stmtEntry, the most recently used first
+	items map[stmtKey]*list.Element
+}
+
+type stmtKey struct {
+	db    *sqlx.DB
+	query string
+}
+
+// stmtEntry is a cached statement. It counts the queries running on it, so that a statement
+// evicted while in use is only closed once they are done.
+type stmtEntry struct {
+	key     stmtKey
+	stmt    *sqlx.Stmt
+	refs    int
+	evicted bool
+}
+
+// PrepareStatements enables a cache of up to size prepared statements, keyed by their SQL, so that
+// the queries weasel runs again and again (Find, FindBy, Exists, Save...) are parsed by the server
+// once. Statements are prepared with sqlx.Preparex on the database (primary or replica) they run
+// on. In transactions, the cached statements of the primary are bound to the transaction with
+// sqlx.Tx.StmtxContext, so that Flush, counter caches and dependent actions use them too. The least
+// recently used statements are closed once the cache is full. It applies to every copy of the connection; calling it again clears the
+// cache, for example after a migration, and a size of 0 disables it. For example:
+//
+//	conn.PrepareStatements(500)
+func (c Connection) PrepareStatements(size int) {
+	if c.stmts == nil {
+		return
+	}
+	c.stmts.mu.Lock()
+	defer c.stmts.mu.Unlock()
+	for _, e := range c.stmts.items {
+		c.stmts.evict(e)
+	}
+	c.stmts.lru = list.New()
+	c.stmts.items = make(map[stmtKey]*list.Element)
+	atomic.StoreInt64(&c.stmts.size, int64(size))
+}
+
+// PreparedStatements returns the number of statements in the connection's cache.
+// See PrepareStatements.
+func (c Connection) PreparedStatements() int {
+	if c.stmts == nil {
+		return 0
+	}
+	c.stmts.mu.Lock()
+	defer c.stmts.mu.Unlock()
+	return len(c.stmts.items)
+}
+
+// get returns the statement of the query on db, preparing it if it is not cached. It returns nil
+// if the cache is disabled or the statement cannot be prepared. Call release once the statement
+// has run.
+func (s *stmtCache) get(ctx context.Context, db *sqlx.DB, query string) *stmtEntry {
+	if atomic.LoadInt64(&s.size) <= 0 {
+		return nil
+	}
+	key := stmtKey{db: db, query: query}
+	s.mu.Lock()
+	if e, ok := s.items[key]; ok {
+		s.lru.MoveToFront(e)
+		entry := e.Value.(*stmtEntry)
+		entry.refs++
+		s.mu.Unlock()
+		return entry
+	}
+	s.mu.Unlock()
+	stmt, err := db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		// Prepared concurrently
+		stmt.Close()
+		s.lru.MoveToFront(e)
+		entry := e.Value.(*stmtEntry)
+		entry.refs++
+		return entry
+	}
+	entry := &stmtEntry{key: key, stmt: stmt, refs: 1}
+	s.items[key] = s.lru.PushFront(entry)
+	for int64(s.lru.Len()) > atomic.LoadInt64(&s.size) {
+		s.evict(s.lru.Back())
+	}
+	return entry
+}
+
+// release marks a query on the statement as done, closing the statement if it was evicted. The
+// rows of a query keep the statement open until they are closed, so it is released as soon as
+// the query returns.
+func (s *stmtCache) release(entry *stmtEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 && entry.evicted {
+		entry.stmt.Close()
+	}
+}
+
+// evict removes the statement from the cache, and closes it unless queries are running on it.
+// The cache must be locked.
+func (s *stmtCache) evict(e *list.Element) {
+	entry := e.Value.(*stmtEntry)
+	s.lru.Remove(e)
+	delete(s.items, entry.key)
+	entry.evicted = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// preparer runs statements through the cache of prepared statements, falling back to what they
+// would run on otherwise: a database, a transaction or a replica writer.
+type preparer struct {
+	sqlx.ExtContext
+	cache *stmtCache
+	db    *sqlx.DB // The database the statements are prepared on
+	tx    *sqlx.Tx // The transaction the statements are bound to, if any
+}
+
+// prepared returns ext, with its statements run through the connection's prepared statement cache.
+func (c Connection) prepared(ext sqlx.ExtContext) sqlx.ExtContext {
+	if c.stmts == nil {
+		return ext
+	}
+	p := preparer{ExtContext: ext, cache: c.stmts, db: c.DB}
+	switch e := ext.(type) {
+	case *sqlx.DB:
+		p.db = e
+	case *sqlx.Tx:
+		p.tx = e
+	case writer:
+		p.db = e.DB
+	}
+	return p
+}
+
+// stmt returns the statement of the query: the cached one, bound to the transaction if there is
+// one, or nil if it is not cached. Call the returned function once the statement has run.
+func (p preparer) stmt(ctx context.Context, query string) (*sqlx.Stmt, func()) {
+	e := p.cache.get(ctx, p.db, query)
+	if e == nil {
+		return nil, nil
+	}
+	if p.tx == nil {
+		return e.stmt, func() { p.cache.release(e) }
+	}
+	// The statement bound to the transaction shares the driver's statement with the cached one,
+	// so closing it once it ran leaves the cached statement, and the rows it returned, open.
+	stmt := p.tx.StmtxContext(ctx, e.stmt)
+	return stmt, func() {
+		stmt.Close()
+		p.cache.release(e)
+	}
+}
+
+func (p preparer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt, done := p.stmt(ctx, query); stmt != nil {
+		defer done()
+		if w, ok := p.ExtContext.(writer); ok {
+			w.replicas.wrote()
+		}
+		return stmt.ExecContext(ctx, args...)
+	}
+	return p.ExtContext.ExecContext(ctx, query, args...)
+}
+
+func (p preparer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if stmt, done := p.stmt(ctx, query); stmt != nil {
+		defer done()
+		return stmt.QueryContext(ctx, args...)
+	}
+	return p.ExtContext.QueryContext(ctx, query, args...)
+}
+
+func (p preparer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt, done := p.stmt(ctx, query); stmt != nil {
+		defer done()
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return p.ExtContext.(stdRunner).QueryRowContext(ctx, query, args...)
+}
+
+func (p preparer) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	if stmt, done := p.stmt(ctx, query); stmt != nil {
+		defer done()
+		return stmt.QueryxContext(ctx, args...)
+	}
+	return p.ExtContext.QueryxContext(ctx, query, args...)
+}
+
+func (p preparer) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	if stmt, done := p.stmt(ctx, query); stmt != nil {
+		defer done()
+		return stmt.QueryRowxContext(ctx, args...)
+	}
+	return p.ExtContext.QueryRowxContext(ctx, query, args...)
+}
@@ -0,0 +1,205 @@
+package weasel
+
+import (
+	"context"
+	"fmt"
+)
+
+type tenantKey struct{}
+
+// ContextWithTenant returns a copy of ctx that carries the tenant id. Pass it to
+// Connection.WithContext or Group.WithContext to run queries for that tenant.
+func ContextWithTenant(ctx context.Context, id any) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// TenantFromContext returns the tenant id carried by ctx, if any.
+func TenantFromContext(ctx context.Context) (any, bool) {
+	id := ctx.Value(tenantKey{})
+	return id, id != nil
+}
+
+// ForTenant returns a copy of the connection that runs queries for the tenant with the given id.
+// There are two modes of multi-tenancy, which can be combined:
+//   - column mode: models with a field tagged `tenant` (for example `db:"tenant_id" tenant:""`)
+//     are filtered by that column in every query, and created documents get the tenant's id.
+//     Queries of these models on a connection without a tenant return ErrNoTenant, unless the
+//     connection was made with AllTenants.
+//   - schema mode: on a connection made with SchemaPerTenant, table names are qualified with the
+//     tenant's schema.
+func (c Connection) ForTenant(id any) Connection {
+	c.tenant = id
+	c.hasTenant = true
+	c.allTenants = false
+	return c
+}
+
+// AllTenants returns a copy of the connection without a tenant, whose queries may read and write
+// the documents of every tenant. It is the explicit escape hatch for cross-tenant access, for
+// example in admin tools and migrations.
+func (c Connection) AllTenants() Connection {
+	c.tenant = nil
+	c.hasTenant = false
+	c.allTenants = true
+	return c
+}
+
+// Tenant returns the tenant id of the connection, if it has one.
+func (c Connection) Tenant() (any, bool) {
+	return c.tenant, c.hasTenant
+}
+
+// SchemaPerTenant returns a copy of the connection in schema mode: when it has a tenant, the
+// tables are qualified with the schema that schema returns for the tenant's id. For example:
+//
+//	tenants := conn.SchemaPerTenant(func(id any) string { return fmt.Sprint("tenant_", id) })
+//	Person.Using(tenants.ForTenant(7)).All() // SELECT person.* FROM tenant_7.person AS person ...
+func (c Connection) SchemaPerTenant(schema func(id any) string) Connection {
+	c.schema = schema
+	return c
+}
+
+// WithContext returns a copy of the connection that carries ctx. If ctx carries a tenant
+// (see ContextWithTenant), the connection runs queries for that tenant.
+func (c Connection) WithContext(ctx context.Context) Connection {
+	c.ctx = ctx
+	c.rewire()
+	if id, ok := TenantFromContext(ctx); ok {
+		return c.ForTenant(id)
+	}
+	return c
+}
+
+// Context returns the context carried by the connection, or context.Background.
+func (c Connection) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Table returns the name to use for the table in queries. In schema mode, it is qualified with
+// the tenant's schema and aliased to its own name, for example "tenant_7.person AS person", so
+// that columns can still be qualified as person.id. Otherwise, it is the name itself.
+func (c Connection) Table(name string) string {
+	if c.schema == nil || !c.hasTenant {
+		return name
+	}
+	return c.tableAs(name, name)
+}
+
+// tableAs returns the table with the given alias, qualified with the tenant's schema in schema mode.
+func (c Connection) tableAs(name, alias string) string {
+	if c.schema != nil && c.hasTenant {
+		name = fmt.Sprintf("%s.%s", c.schema(c.tenant), name)
+	}
+	return name + " AS " + alias
+}
+
+// tenantWhere returns the condition that restricts the model's rows to the connection's tenant.
+// It is empty if the model has no tenant column, or the connection is for all tenants.
+func (m *Model[Doc]) tenantWhere(conn Connection) (Eq, error) {
+	return m.tenantWhereAs(conn, m.tableName)
+}
+
+// tenantWhereAs is tenantWhere for the model's table named alias in the query, as in joins.
+func (m *Model[Doc]) tenantWhereAs(conn Connection, alias string) (Eq, error) {
+	if m.tenant == "" || conn.allTenants {
+		return Eq{}, nil
+	}
+	if !conn.hasTenant {
+		return nil, ErrNoTenant
+	}
+	return Eq{alias + "." + m.tenant: conn.tenant}, nil
+}
+
+// assignTenant sets the tenant column of a document created on conn to the connection's tenant.
+func (m *Model[Doc]) assignTenant(conn Connection, doc Doc) error {
+	if m.tenant == "" || conn.allTenants {
+		return nil
+	}
+	if !conn.hasTenant {
+		return ErrNoTenant
+	}
+	m.layout.set(doc, m.tenant, conn.tenant)
+	return nil
+}
+
+// TenantKey returns the tenant column of the document's table, or an empty string if the
+// model is not multi-tenant. Validations such as uniqueness use it to stay within a tenant.
+func (d Document[Doc]) TenantKey() string {
+	return d.Model.tenant
+}
+
+// binder is implemented by every document through the embedded Document.
+type binder interface {
+	bind(conn Connection)
+	release()
+}
+
+// bind records the connection the document was loaded or created on, so that it is saved,
+// deleted, validated and related on the same tenant, and in the same transaction until it ends
+// (see Document.Conn).
+func (d *Document[Doc]) bind(conn Connection) {
+	d.conn = &conn
+}
+
+// release binds the document to its connection without the context of the operation that loaded
+// or created it, if any (see finish).
+func (d *Document[Doc]) release() {
+	if d.conn != nil && d.conn.operating {
+		conn := d.conn.released()
+		d.conn = &conn
+	}
+}
+
+func bind(doc any, conn Connection) {
+	if b, ok := doc.(binder); ok {
+		b.bind(conn)
+	}
+}
+
+// Using returns a copy of the group whose queries run on conn, for example a connection made
+// with ForTenant. Documents loaded or created by the group keep using conn.
+func (m Group[Doc]) Using(conn Connection) *Group[Doc] {
+	m.conn = &conn
+	return &m
+}
+
+// ForTenant returns a copy of the group whose queries run for the tenant with the given id.
+// See Connection.ForTenant.
+func (m Group[Doc]) ForTenant(id any) *Group[Doc] {
+	return m.Using(m.connection().ForTenant(id))
+}
+
+// AllTenants returns a copy of the group whose queries are not restricted to a tenant.
+// See Connection.AllTenants.
+func (m Group[Doc]) AllTenants() *Group[Doc] {
+	return m.Using(m.connection().AllTenants())
+}
+
+// WithContext returns a copy of the group whose queries carry ctx, and run for its tenant
+// if it has one. See Connection.WithContext.
+func (m Group[Doc]) WithContext(ctx context.Context) *Group[Doc] {
+	return m.Using(m.connection().WithContext(ctx))
+}
+
+// connection returns the connection the group's queries run on.
+func (m Group[Doc]) connection() Connection {
+	if m.conn != nil {
+		return *m.conn
+	}
+	return m.Model.Conn
+}
+
+// on returns the model's group, running on the connection of owner when it uses the same
+// database, so that relations follow the owner's tenant.
+func (m *Model[Doc]) on(owner DocumentBase) *Group[Doc] {
+	g := *m.Group
+	if owner != nil {
+		if c := owner.Conn(); c.DB == m.Conn.DB {
+			g.conn = &c
+		}
+	}
+	return &g
+}
@@ -0,0 +1,148 @@
+package weasel
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Add links the documents to the owner of a has many through group, by inserting
+// join table rows. Documents that are already linked are skipped. For example:
+//
+//	john.Friends().Add(jane, bob)
+func (m Group[Doc]) Add(docs ...Doc) error {
+	return m.AddWith(nil, docs...)
+}
+
+// AddWith is like Add, but also sets extra columns of the join table rows. For example:
+//
+//	john.Friends().AddWith(map[string]any{"created_at": time.Now()}, jane)
+func (m Group[Doc]) AddWith(extra map[string]any, docs ...Doc) error {
+	if m.rel == nil || m.rel.Through == "" {
+		return ErrNotThrough
+	}
+	return m.connection().Transaction(func(tx Connection) error {
+		return m.add(tx, extra, docs)
+	})
+}
+
+// Remove unlinks the documents from the owner of a has many through group, by deleting
+// their join table rows. The documents themselves are not deleted.
+func (m Group[Doc]) Remove(docs ...Doc) error {
+	if m.rel == nil || m.rel.Through == "" {
+		return ErrNotThrough
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	ids := make([]any, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d.Get(m.Model.pk))
+	}
+	conn := m.connection()
+	_, err := conn.Builder.Delete(conn.Table(m.rel.Through)).Where(Eq{m.rel.Key: m.owner, m.rel.ForeignKey: ids}).Exec()
+	conn.onCommit(m.Model.invalidate)
+	return err
+}
+
+// Replace makes the documents the only ones linked to the owner of a has many through group.
+// The existing join table rows are deleted and the new ones inserted in one transaction.
+func (m Group[Doc]) Replace(docs ...Doc) error {
+	if m.rel == nil || m.rel.Through == "" {
+		return ErrNotThrough
+	}
+	return m.connection().Transaction(func(tx Connection) error {
+		if err := m.clear(tx); err != nil {
+			return err
+		}
+		return m.add(tx, nil, docs)
+	})
+}
+
+// Clear unlinks every document from the owner of a has many through group.
+func (m Group[Doc]) Clear() error {
+	if m.rel == nil || m.rel.Through == "" {
+		return ErrNotThrough
+	}
+	return m.clear(m.connection())
+}
+
+// Has checks if the document is linked to the owner of a has many through group.
+func (m Group[Doc]) Has(doc Doc) (bool, error) {
+	if m.rel == nil || m.rel.Through == "" {
+		return false, ErrNotThrough
+	}
+	var cnt int
+	conn := m.connection()
+	err := conn.Builder.Select("COUNT(1)").
+		From(conn.Table(m.rel.Through)).
+		Where(Eq{m.rel.Key: m.owner, m.rel.ForeignKey: doc.Get(m.Model.pk)}).
+		Scan(&cnt)
+	return cnt != 0, err
+}
+
+func (m Group[Doc]) add(conn Connection, extra map[string]any, docs []Doc) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	ids := make([]any, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d.Get(m.Model.pk))
+	}
+	linked, err := m.linked(conn, ids)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	q := conn.Builder.Insert(conn.Table(m.rel.Through)).Columns(append([]string{m.rel.Key, m.rel.ForeignKey}, keys...)...)
+	rows := 0
+	for _, id := range ids {
+		if linked[fmt.Sprint(id)] {
+			continue
+		}
+		linked[fmt.Sprint(id)] = true
+		values := []any{m.owner, id}
+		for _, k := range keys {
+			values = append(values, extra[k])
+		}
+		q = q.Values(values...)
+		rows++
+	}
+	if rows == 0 {
+		return nil
+	}
+	_, err = q.Exec()
+	conn.onCommit(m.Model.invalidate)
+	return err
+}
+
+// linked returns which of the ids already have a join table row for the owner, keyed by their
+// string form.
+func (m Group[Doc]) linked(conn Connection, ids []any) (map[string]bool, error) {
+	rows, err := conn.Builder.Select(m.rel.ForeignKey).
+		From(conn.Table(m.rel.Through)).
+		Where(Eq{m.rel.Key: m.owner, m.rel.ForeignKey: ids}).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	linked := make(map[string]bool)
+	for rows.Next() {
+		var id any
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		linked[fmt.Sprint(id)] = true
+	}
+	return linked, rows.Err()
+}
+
+func (m Group[Doc]) clear(conn Connection) error {
+	_, err := conn.Builder.Delete(conn.Table(m.rel.Through)).Where(Eq{m.rel.Key: m.owner}).Exec()
+	conn.onCommit(m.Model.invalidate)
+	return err
+}
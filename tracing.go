@@ -0,0 +1,247 @@
+package weasel
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Tracer starts spans. Implement it to wire weasel to your tracing system; for OpenTelemetry,
+// see TracerFunc. SpanRecorder is an in-memory implementation for tests.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, and returns a context holding it.
+	Start(ctx context.Context, name string, attrs map[string]any) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// TracerFunc adapts a function to the Tracer interface. Together with SpanFuncs, it lets you wire
+// OpenTelemetry (or any other tracing library) without weasel depending on it, for example:
+//
+//	otelTracer := otel.Tracer("weasel")
+//	tracer := weasel.TracerFunc(func(ctx context.Context, name string, attrs map[string]any) (context.Context, weasel.Span) {
+//		ctx, span := otelTracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		set := func(k string, v any) { span.SetAttributes(attribute.String(k, fmt.Sprint(v))) }
+//		for k, v := range attrs {
+//			set(k, v)
+//		}
+//		return ctx, weasel.SpanFuncs{
+//			SetAttributeFunc: set,
+//			RecordErrorFunc: func(err error) {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			},
+//			EndFunc: func() { span.End() },
+//		}
+//	})
+//	conn.AddHook(weasel.TracingHook(tracer))
+type TracerFunc func(ctx context.Context, name string, attrs map[string]any) (context.Context, Span)
+
+// Start implements Tracer.
+func (f TracerFunc) Start(ctx context.Context, name string, attrs map[string]any) (context.Context, Span) {
+	return f(ctx, name, attrs)
+}
+
+// SpanFuncs adapts functions to the Span interface. Nil functions are skipped.
+type SpanFuncs struct {
+	SetAttributeFunc func(key string, value any)
+	RecordErrorFunc  func(err error)
+	EndFunc          func()
+}
+
+func (s SpanFuncs) SetAttribute(key string, value any) {
+	if s.SetAttributeFunc != nil {
+		s.SetAttributeFunc(key, value)
+	}
+}
+
+func (s SpanFuncs) RecordError(err error) {
+	if s.RecordErrorFunc != nil {
+		s.RecordErrorFunc(err)
+	}
+}
+
+func (s SpanFuncs) End() {
+	if s.EndFunc != nil {
+		s.EndFunc()
+	}
+}
+
+// TracingHook returns a query hook that runs every operation of weasel (see OperationHook), and
+// every query, in a span started from the context of the group (see Group.WithContext) or
+// document (see Document.SetContext) that runs it. The queries of an operation, and the
+// operations it nests, are children of its span, so that a Save shows its validations, counter
+// caches and transaction under it.
+//
+// Operation spans are named "<model>.<method>", for example "person.Save", and carry the
+// attributes db.sql.table, weasel.operation and, for relation loads, weasel.relation. Query spans
+// are named "<operation> <model>", for example "find person", and carry the attributes db.system,
+// db.statement (with literals replaced by ?), db.operation, db.sql.table and, once the query ran,
+// db.rows.
+func TracingHook(tracer Tracer) QueryHook {
+	return &tracingHook{tracer: tracer}
+}
+
+type tracingHook struct {
+	tracer Tracer
+}
+
+type spanKey struct {
+	hook *tracingHook
+}
+
+func (h *tracingHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	name := string(event.Operation)
+	if event.Model != "" {
+		name += " " + event.Model
+	}
+	ctx, span := h.tracer.Start(ctx, name, map[string]any{
+		"db.system":    dbSystem(event.Driver),
+		"db.statement": sanitize(event.SQL),
+		"db.operation": string(event.Operation),
+		"db.sql.table": event.Model,
+	})
+	return context.WithValue(ctx, spanKey{h}, span)
+}
+
+func (h *tracingHook) BeforeOperation(ctx context.Context, event *OperationEvent) context.Context {
+	attrs := map[string]any{
+		"db.sql.table":     event.Model,
+		"weasel.operation": event.Name,
+	}
+	if event.Relation != "" {
+		attrs["weasel.relation"] = event.Relation
+	}
+	ctx, span := h.tracer.Start(ctx, event.Model+"."+event.Name, attrs)
+	return context.WithValue(ctx, spanKey{h}, span)
+}
+
+func (h *tracingHook) AfterOperation(ctx context.Context, event *OperationEvent) {
+	span, ok := ctx.Value(spanKey{h}).(Span)
+	if !ok {
+		return
+	}
+	if event.Err != nil {
+		span.RecordError(event.Err)
+	}
+	span.End()
+}
+
+func (h *tracingHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	span, ok := ctx.Value(spanKey{h}).(Span)
+	if !ok {
+		return
+	}
+	span.SetAttribute("db.rows", event.Rows)
+	if event.Err != nil {
+		span.RecordError(event.Err)
+	}
+	span.End()
+}
+
+// dbSystem returns the OpenTelemetry db.system of the driver.
+func dbSystem(driver string) string {
+	switch driver {
+	case "postgres":
+		return "postgresql"
+	case "sqlite", "sqlite3":
+		return "sqlite"
+	}
+	return driver
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteral = regexp.MustCompile(`([^\w$.])\d+(?:\.\d+)?\b`)
+)
+
+// sanitize replaces the string and numeric literals of the query with ?, so that values written
+// into raw where clauses do not end up in traces. Placeholders such as $1 are kept.
+func sanitize(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "?")
+	return numericLiteral.ReplaceAllString(query, "${1}?")
+}
+
+// SpanRecorder is a Tracer that keeps the spans in memory, for tests.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span kept by a SpanRecorder.
+type RecordedSpan struct {
+	ID         int
+	ParentID   int // 0 if the span has no parent
+	Name       string
+	Attributes map[string]any
+	Err        error
+	Start      time.Time
+	Duration   time.Duration
+	Ended      bool
+	recorder   *SpanRecorder
+}
+
+type recordedSpanKey struct{}
+
+// Start implements Tracer.
+func (r *SpanRecorder) Start(ctx context.Context, name string, attrs map[string]any) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &RecordedSpan{ID: len(r.spans) + 1, Name: name, Attributes: make(map[string]any), Start: time.Now(), recorder: r}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok {
+		span.ParentID = parent.ID
+	}
+	for k, v := range attrs {
+		span.Attributes[k] = v
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns copies of the recorded spans, in the order they were started.
+func (r *SpanRecorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]RecordedSpan, 0, len(r.spans))
+	for _, s := range r.spans {
+		c := *s
+		c.Attributes = make(map[string]any, len(s.Attributes))
+		for k, v := range s.Attributes {
+			c.Attributes[k] = v
+		}
+		spans = append(spans, c)
+	}
+	return spans
+}
+
+// Reset removes the recorded spans.
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+func (s *RecordedSpan) SetAttribute(key string, value any) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.Err = err
+}
+
+func (s *RecordedSpan) End() {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.Duration = time.Since(s.Start)
+	s.Ended = true
+}
@@ -14,3 +14,7 @@ type ILike = sq.ILike
 type NotILike = sq.NotILike
 type And = sq.And
 type Or = sq.Or
+
+// SelectBuilder is squirrel's select builder, used to narrow the subqueries of WhereHas
+// and WhereDoesntHave.
+type SelectBuilder = sq.SelectBuilder
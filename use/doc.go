@@ -10,6 +10,10 @@
 //   - BelongsTo
 //   - HasMany
 //   - HasOne
+//   - BelongsToAny (polymorphic)
+//
+// When a schema has several relations to the same table, BelongsTo, HasMany and HasOne fill all
+// of them; BelongsToField, HasManyField and HasOneField fill a single field.
 //
 // Utilities:
 //   - Validate Presence
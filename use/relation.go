@@ -1,8 +1,9 @@
 package use
 
 import (
-	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 
 	"github.com/ztcollazo/weasel"
 )
@@ -13,23 +14,35 @@ type document[Doc weasel.DocumentBase] interface {
 	GetModel() *weasel.Model[Doc]
 }
 
-// use.HasMany populates the field that returns the has many relationship, specified in the schema.
-// It takes a document and the model that the document has many of.
+// use.HasMany populates the fields that return has many relationships, specified in the schema.
+// It takes the model that the document has many of, and fills every has many field pointing to
+// that model's table. Use HasManyField to fill only one of them.
 func HasMany[Doc document[Doc], Rel document[Rel]](model *weasel.Model[Rel]) weasel.Middleware {
 	return func(doc weasel.DocumentBase) {
 		dm := doc.Get("Model").(*weasel.Model[Doc])
-		rel := dm.Relations()["hasMany"+model.Name()]
-		var fn weasel.HasMany[Rel] = func() *weasel.Group[Rel] {
-			if rel.Through != "" {
-				return weasel.NewGroupWith(weasel.Eq{}, model, rel.Through, rel.Through+"."+rel.Key, fmt.Sprint(doc.Get(doc.PrimaryKey())), model.GetOrder(), make(map[string]*weasel.Group[Rel]))
-			} else {
-				return weasel.NewGroupWith(weasel.Eq{rel.ForeignKey: doc.Get(rel.Key)}, model, "", "", "", model.GetOrder(), make(map[string]*weasel.Group[Rel]))
-			}
+		for _, rel := range relationsTo(dm, "hasMany", model.Name()) {
+			hasMany(doc, rel, model)
 		}
-		doc.Set(rel.Name, fn)
 	}
 }
 
+// use.HasManyField populates the has many relationship of the given field with the given model.
+// It panics if the document has no has many field with that name.
+func HasManyField[Doc document[Doc], Rel document[Rel]](field string, model *weasel.Model[Rel]) weasel.Middleware {
+	mustHaveField[Doc]("HasManyField", field, "hasmany")
+	return func(doc weasel.DocumentBase) {
+		dm := doc.Get("Model").(*weasel.Model[Doc])
+		hasMany(doc, dm.Relations()[field], model)
+	}
+}
+
+func hasMany[Rel document[Rel]](doc weasel.DocumentBase, rel weasel.Relation, model *weasel.Model[Rel]) {
+	var fn weasel.HasMany[Rel] = func() *weasel.Group[Rel] {
+		return weasel.NewRelationGroup(model, rel, doc)
+	}
+	doc.Set(rel.Name, fn)
+}
+
 // func UseHasMany[Doc document[Doc], Rel document[Rel]](doc Doc, model *weasel.Model[Rel]) {
 // 	rel := doc.GetModel().Relations()["hasMany"+model.Name()]
 // 	var fn weasel.HasMany[Rel] = func(d ...Rel) weasel.Group[Rel] {
@@ -55,28 +68,62 @@ func HasMany[Doc document[Doc], Rel document[Rel]](model *weasel.Model[Rel]) wea
 // 	doc.Set(rel.Name, fn)
 // }
 
-// use.BelongsTo populates the field that returns the belongs to relationship, specified in the schema.
-// It takes a document and the model that the document belongs to.
+// use.BelongsTo populates the fields that return belongs to relationships, specified in the schema.
+// It takes the model that the document belongs to, and fills every belongs to field pointing to
+// that model's table. For example, a message with both a Sender and a Recipient belonging to
+// person gets both filled, each with its own key. Use BelongsToField to fill only one of them.
 func BelongsTo[Doc document[Doc], Rel document[Rel]](model *weasel.Model[Rel]) weasel.Middleware {
 	return func(doc weasel.DocumentBase) {
 		dm := doc.Get("Model").(*weasel.Model[Doc])
-		rel := dm.Relations()["belongsTo"+model.Name()]
-		var fn weasel.BelongsTo[Rel] = func() (Rel, error) {
-			e, err := weasel.Select([]string{"*"}, model).Where(weasel.Eq{rel.ForeignKey: doc.Get(rel.Key)}).Exec()
-			if err == nil {
-				e.Create(e, model)
-				e.Init()
-				if len(e.AllErrors()) > 0 {
-					return e, errors.New("document is invalid")
-				}
-				return e, nil
+		for _, rel := range relationsTo(dm, "belongsTo", model.Name()) {
+			belongsTo(doc, rel, model)
+		}
+	}
+}
+
+// use.BelongsToField populates the belongs to relationship of the given field with the given model.
+// It panics if the document has no belongs to field with that name.
+//
+//	m.Use(use.BelongsToField[*MessageSchema]("Sender", Person))
+func BelongsToField[Doc document[Doc], Rel document[Rel]](field string, model *weasel.Model[Rel]) weasel.Middleware {
+	mustHaveField[Doc]("BelongsToField", field, "belongsto")
+	return func(doc weasel.DocumentBase) {
+		dm := doc.Get("Model").(*weasel.Model[Doc])
+		belongsTo(doc, dm.Relations()[field], model)
+	}
+}
+
+func belongsTo[Rel document[Rel]](doc weasel.DocumentBase, rel weasel.Relation, model *weasel.Model[Rel]) {
+	var fn weasel.BelongsTo[Rel] = func() (Rel, error) {
+		return on(model, doc).FindBy(model.Name()+"."+rel.ForeignKey, doc.Get(rel.Key))
+	}
+	doc.Set(rel.Name, fn)
+}
+
+// use.BelongsToAny populates the fields that return polymorphic belongs to relationships, specified
+// in the schema with the `polymorphic` tag. It takes the name of the relation (for example commentable)
+// or its field, and resolves the related model by the table name stored in the type column, from the
+// connection's registry.
+func BelongsToAny[Doc document[Doc]](name string) weasel.Middleware {
+	return func(doc weasel.DocumentBase) {
+		dm := doc.Get("Model").(*weasel.Model[Doc])
+		for _, rel := range sorted(dm.Relations()) {
+			if rel.Polymorphic == "" || rel.Variant != "belongsTo" || (rel.Polymorphic != name && rel.Name != name) {
+				continue
 			}
-			return e, err
+			belongsToAny(doc, rel)
 		}
-		doc.Set(rel.Name, fn)
 	}
 }
 
+func belongsToAny(doc weasel.DocumentBase, rel weasel.Relation) {
+	var fn weasel.BelongsToAny = func() (weasel.DocumentBase, error) {
+		table := fmt.Sprint(doc.Get(rel.TypeKey()))
+		return doc.Conn().Registry().FindFor(doc, table, weasel.Eq{table + "." + rel.ForeignKey: doc.Get(rel.Key)})
+	}
+	doc.Set(rel.Name, fn)
+}
+
 // func UseBelongsTo[Doc document[Doc], Rel document[Rel]](doc Doc, model *weasel.Model[Rel]) {
 // 	rel := doc.GetModel().Relations()["belongsTo"+model.Name()]
 // 	var fn weasel.BelongsTo[Rel] = func(d ...Rel) (Rel, error) {
@@ -93,28 +140,84 @@ func BelongsTo[Doc document[Doc], Rel document[Rel]](model *weasel.Model[Rel]) w
 // 	doc.Set(rel.Name, fn)
 // }
 
-// use.HasOne populates the field that returns the has one relationship, specified in the schema.
-// it takes a document and the model that the document has one of.
+// use.HasOne populates the fields that return has one relationships, specified in the schema.
+// It takes the model that the document has one of, and fills every has one field pointing to
+// that model's table. Use HasOneField to fill only one of them.
 func HasOne[Doc document[Doc], Rel document[Rel]](model *weasel.Model[Rel]) weasel.Middleware {
 	return func(doc weasel.DocumentBase) {
 		dm := doc.Get("Model").(*weasel.Model[Doc])
-		rel := dm.Relations()["hasOne"+model.Name()]
-		var fn weasel.HasOne[Rel] = func() (Rel, error) {
-			e, err := weasel.Select([]string{"*"}, model).Where(weasel.Eq{rel.ForeignKey: doc.Get(rel.Key)}).Exec()
-			if err == nil {
-				e.Create(e, model)
-				e.Init()
-				if len(e.AllErrors()) > 0 {
-					return e, errors.New("document is invalid")
-				}
-				return e, nil
-			}
-			return e, err
+		for _, rel := range relationsTo(dm, "hasOne", model.Name()) {
+			hasOne(doc, rel, model)
 		}
-		doc.Set(rel.Name, fn)
 	}
 }
 
+// use.HasOneField populates the has one relationship of the given field with the given model.
+// It panics if the document has no has one field with that name.
+func HasOneField[Doc document[Doc], Rel document[Rel]](field string, model *weasel.Model[Rel]) weasel.Middleware {
+	mustHaveField[Doc]("HasOneField", field, "hasone")
+	return func(doc weasel.DocumentBase) {
+		dm := doc.Get("Model").(*weasel.Model[Doc])
+		hasOne(doc, dm.Relations()[field], model)
+	}
+}
+
+func hasOne[Rel document[Rel]](doc weasel.DocumentBase, rel weasel.Relation, model *weasel.Model[Rel]) {
+	var fn weasel.HasOne[Rel] = func() (Rel, error) {
+		// The relation group holds the foreign key (and type) conditions.
+		return weasel.NewRelationGroup(model, rel, doc).First()
+	}
+	doc.Set(rel.Name, fn)
+}
+
+// mustHaveField panics if the schema of Doc has no field with the given name and relation tag,
+// so that a misspelled field fails when the middleware is built, not when it first runs.
+func mustHaveField[Doc any](fn, field, tag string) {
+	t := reflect.TypeOf((*Doc)(nil)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if f, ok := t.FieldByName(field); ok {
+		if _, ok := f.Tag.Lookup(tag); ok {
+			return
+		}
+	}
+	panic(fmt.Sprintf("use.%s: %s has no %s field %s", fn, t, tag, field))
+}
+
+// on returns the model's group on the document's connection when it uses the same database,
+// so that the relation follows the document's tenant.
+func on[Rel document[Rel]](model *weasel.Model[Rel], doc weasel.DocumentBase) *weasel.Group[Rel] {
+	if c := doc.Conn(); c.DB == model.Conn.DB {
+		return model.Using(c)
+	}
+	return model.Group
+}
+
+// relationsTo returns the relations of the given variant pointing to table, sorted by field name.
+func relationsTo[Doc document[Doc]](model *weasel.Model[Doc], variant, table string) []weasel.Relation {
+	rels := make([]weasel.Relation, 0)
+	for _, rel := range sorted(model.Relations()) {
+		if rel.Variant == variant && rel.Table == table {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+func sorted(relations map[string]weasel.Relation) []weasel.Relation {
+	names := make([]string, 0, len(relations))
+	for name := range relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rels := make([]weasel.Relation, 0, len(names))
+	for _, name := range names {
+		rels = append(rels, relations[name])
+	}
+	return rels
+}
+
 // func UseHasOne[Doc document[Doc], Rel document[Rel]](doc Doc, model *weasel.Model[Rel]) {
 // 	rel := doc.GetModel().Relations()["hasOne"+model.Name()]
 // 	var fn weasel.HasOne[Rel] = func(d ...Rel) (Rel, error) {
@@ -14,7 +14,7 @@ import (
 //	doc.Use(use.ValidatePresenceOf[string]("email"))
 func ValidatePresenceOf[T any](field string) weasel.Middleware {
 	return func(d weasel.DocumentBase) {
-		if !truthy.Value(d.Get(field).(T)) {
+		if d.IsLoaded(field) && !truthy.Value(d.Get(field).(T)) {
 			d.AddError(fmt.Errorf("field %s is not present in document", field))
 		}
 	}
@@ -28,7 +28,7 @@ func ValidatePresenceOf[T any](field string) weasel.Middleware {
 //	}))
 func Validate[T any](field string, validator func(val T) bool) weasel.Middleware {
 	return func(d weasel.DocumentBase) {
-		if !validator(d.Get(field).(T)) {
+		if d.IsLoaded(field) && !validator(d.Get(field).(T)) {
 			d.AddError(fmt.Errorf("field %s is not valid", field))
 		}
 	}
@@ -39,14 +39,23 @@ func Validate[T any](field string, validator func(val T) bool) weasel.Middleware
 // For example, the query would look like: SELECT COUNT(*) FROM table WHERE id = id AND id != id;
 //
 // Use autoincrement or validate their uniqueness internally.
+// On multi-tenant models, the value only has to be unique within the document's tenant.
 //
 //	doc.Use(use.ValidateUniquenessOf("email"))
 func ValidateUniquenessOf(field string) weasel.Middleware {
 	return func(d weasel.DocumentBase) {
+		if !d.IsLoaded(field) {
+			return
+		}
 		var count int
-		err := d.Conn().Builder.Select("COUNT(*)").
-			From(d.Table()).
-			Where(weasel.And{weasel.Eq{field: d.Get(field)}, weasel.NotEq{d.PrimaryKey(): d.Get(d.PrimaryKey())}}).
+		where := weasel.And{weasel.Eq{field: d.Get(field)}, weasel.NotEq{d.PrimaryKey(): d.Get(d.PrimaryKey())}}
+		if tenant := d.TenantKey(); tenant != "" {
+			where = append(where, weasel.Eq{tenant: d.Get(tenant)})
+		}
+
+		err := d.Conn().Instrument(d.Table(), weasel.OpValidate).Builder.Select("COUNT(*)").
+			From(d.Conn().Table(d.Table())).
+			Where(where).
 			QueryRow().
 			Scan(&count)
 
@@ -60,11 +69,17 @@ func ValidateUniquenessOf(field string) weasel.Middleware {
 	}
 }
 
-// ValidateUniqueCombination checks that the combination of fields is unique in the DB.
+// ValidateUniqueCombination checks that the combination of fields is unique in the DB
+// (within the document's tenant, on multi-tenant models).
 //
 //	doc.Use(use.ValidateUniqueCombination("friend_id", "friender_id"))
 func ValidateUniqueCombination(field1, field2 string, fields ...string) weasel.Middleware {
 	return func(d weasel.DocumentBase) {
+		for _, field := range append([]string{field1, field2}, fields...) {
+			if !d.IsLoaded(field) {
+				return
+			}
+		}
 		var count int
 
 		where := weasel.And{weasel.NotEq{d.PrimaryKey(): d.Get(d.PrimaryKey())}, weasel.Eq{field1: d.Get(field1)}, weasel.Eq{field2: d.Get(field2)}}
@@ -72,9 +87,12 @@ func ValidateUniqueCombination(field1, field2 string, fields ...string) weasel.M
 		for _, field := range fields {
 			where = append(where, weasel.Eq{field: d.Get(field)})
 		}
+		if tenant := d.TenantKey(); tenant != "" {
+			where = append(where, weasel.Eq{tenant: d.Get(tenant)})
+		}
 
-		err := d.Conn().Builder.Select("COUNT(*)").
-			From(d.Table()).
+		err := d.Conn().Instrument(d.Table(), weasel.OpValidate).Builder.Select("COUNT(*)").
+			From(d.Conn().Table(d.Table())).
 			Where(where).
 			QueryRow().
 			Scan(&count)
@@ -100,7 +118,7 @@ func ValidateUniqueCombination(field1, field2 string, fields ...string) weasel.M
 //	doc.Use(use.ValidateFormatOf("email", regexp.MustCompile(`[^@ \t\r\n]+@[^@ \t\r\n]+\.[^@ \t\r\n]+`)))
 func ValidateFormatOf(field string, format *regexp.Regexp) weasel.Middleware {
 	return func(d weasel.DocumentBase) {
-		if !format.MatchString(d.Get(field).(string)) {
+		if d.IsLoaded(field) && !format.MatchString(d.Get(field).(string)) {
 			d.AddError(fmt.Errorf("field %s does not match the specified pattern %s", field, format))
 		}
 	}
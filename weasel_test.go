@@ -1,10 +1,18 @@
 package weasel_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
 	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -17,6 +25,10 @@ var schema = `
 DROP TABLE IF EXISTS person;
 DROP TABLE IF EXISTS friends;
 DROP TABLE IF EXISTS place;
+DROP TABLE IF EXISTS comment;
+DROP TABLE IF EXISTS message;
+DROP TABLE IF EXISTS note;
+DROP SCHEMA IF EXISTS tenant_a CASCADE;
 
 CREATE TABLE person (
 		id serial primary key,
@@ -29,22 +41,53 @@ CREATE TABLE person (
 CREATE TABLE friends (
 	id serial primary key,
 	friender integer,
-	friended integer
+	friended integer,
+	note text
 );
 
 CREATE TABLE place (
 		id serial primary key,
     country text,
     city text NULL,
-    telcode integer
+    telcode integer,
+    people_count integer DEFAULT 0
 );
 
+CREATE TABLE comment (
+	id serial primary key,
+	body text,
+	commentable_type text,
+	commentable_id integer,
+	hidden boolean DEFAULT false
+);
+
+CREATE TABLE message (
+	id serial primary key,
+	body text,
+	sender_id integer,
+	recipient_id integer
+);
+
+CREATE TABLE note (
+	id serial primary key,
+	tenant_id integer NOT NULL,
+	body text,
+	place_id integer
+);
+
+CREATE SCHEMA tenant_a;
+CREATE TABLE tenant_a.place (LIKE place INCLUDING ALL);
+
+CREATE UNIQUE INDEX place_city ON place (city);
+
 INSERT INTO person (first_name, last_name, email, place_id) VALUES ('John', 'Doe', 'john@doe.com', 1);
 INSERT INTO person (first_name, last_name, email, place_id) VALUES ('Jane', 'Doe', 'jane@doe.net', 1);
 
 INSERT INTO friends (friender, friended) VALUES (1, 2);
 
-INSERT INTO place (country, city, telcode) VALUES ('United States of America', 'Chicago', 1);`
+INSERT INTO place (country, city, telcode) VALUES ('United States of America', 'Chicago', 1);
+
+INSERT INTO message (body, sender_id, recipient_id) VALUES ('Hi Jane', 1, 2);`
 
 type PersonSchema struct {
 	weasel.Document[*PersonSchema]
@@ -53,18 +96,50 @@ type PersonSchema struct {
 	LastName  string                         `db:"last_name" type:"text"`
 	Email     string                         `db:"email" type:"text"`
 	PlaceId   int                            `db:"place_id" type:"integer"`
-	Place     weasel.BelongsTo[*PlaceSchema] `belongsto:"place" fk:"id" key:"place_id"`
-	Friends   weasel.HasMany[*PersonSchema]  `hasmany:"person" through:"friends" key:"friender" fk:"friended"`
+	Place     weasel.BelongsTo[*PlaceSchema] `belongsto:"place" fk:"id" key:"place_id" countercache:"people_count"`
+	Friends   weasel.HasMany[*PersonSchema]  `hasmany:"person" through:"friends" key:"friender" fk:"friended" dependent:"delete"`
+	Comments  weasel.HasMany[*CommentSchema] `hasmany:"comment" polymorphic:"commentable" dependent:"destroy"`
+	Sent      weasel.HasMany[*MessageSchema] `hasmany:"message" fk:"sender_id" dependent:"nullify"`
+	Received  weasel.HasMany[*MessageSchema] `hasmany:"message" fk:"recipient_id"`
 	Hello     string
 }
 
 type PlaceSchema struct {
 	weasel.Document[*PlaceSchema]
-	Id      int                           `db:"id" pk:"" type:"serial"`
-	Country string                        `db:"country" type:"text"`
-	City    string                        `db:"city" type:"text"`
-	Telcode int                           `db:"telcode" type:"integer"`
-	People  weasel.HasMany[*PersonSchema] `hasmany:"person" fk:"place_id" key:"id"`
+	Id          int                            `db:"id" pk:"" type:"serial"`
+	Country     string                         `db:"country" type:"text"`
+	City        string                         `db:"city" type:"text"`
+	Telcode     int                            `db:"telcode" type:"integer"`
+	PeopleCount int                            `db:"people_count" type:"integer"`
+	People      weasel.HasMany[*PersonSchema]  `hasmany:"person" fk:"place_id" key:"id" dependent:"restrict"`
+	Comments    weasel.HasMany[*CommentSchema] `hasmany:"comment" polymorphic:"commentable"`
+}
+
+type CommentSchema struct {
+	weasel.Document[*CommentSchema]
+	Id              int                 `db:"id" pk:"" type:"serial"`
+	Body            string              `db:"body" type:"text"`
+	CommentableType string              `db:"commentable_type" type:"text"`
+	CommentableId   int                 `db:"commentable_id" type:"integer"`
+	Hidden          bool                `db:"hidden" type:"boolean"`
+	Commentable     weasel.BelongsToAny `belongsto:"" polymorphic:"commentable"`
+}
+
+type MessageSchema struct {
+	weasel.Document[*MessageSchema]
+	Id          int                             `db:"id" pk:"" type:"serial"`
+	Body        string                          `db:"body" type:"text"`
+	SenderId    int                             `db:"sender_id" type:"integer"`
+	RecipientId int                             `db:"recipient_id" type:"integer"`
+	Sender      weasel.BelongsTo[*PersonSchema] `belongsto:"person" key:"sender_id"`
+	Recipient   weasel.BelongsTo[*PersonSchema] `belongsto:"person" key:"recipient_id"`
+}
+
+type NoteSchema struct {
+	weasel.Document[*NoteSchema]
+	Id       int    `db:"id" pk:"" type:"serial"`
+	TenantId int    `db:"tenant_id" type:"integer" tenant:""`
+	Body     string `db:"body" type:"text"`
 }
 
 var conn = weasel.Connect("postgres", weasel.Opts{
@@ -78,6 +153,12 @@ var Person = weasel.Create(conn, &PersonSchema{}, "person", func(m *weasel.Model
 	m.Set("hello", "world")
 })
 
+var Comment = weasel.Create(conn, &CommentSchema{}, "comment")
+
+var Message = weasel.Create(conn, &MessageSchema{}, "message")
+
+var Note = weasel.Create(conn, &NoteSchema{}, "note")
+
 func (p *PersonSchema) Init() {
 	p.Hello = "world"
 	// Deprecated:
@@ -86,6 +167,7 @@ func (p *PersonSchema) Init() {
 	//	weasel.UseHasMany(p, Person)
 	p.Use(use.HasMany[*PersonSchema](Person))
 	p.Use(use.BelongsTo[*PersonSchema](Place))
+	p.Use(use.HasMany[*PersonSchema](Comment))
 	p.Use(use.ValidatePresenceOf[string]("email"))
 	p.Use(use.ValidateFormatOf("email", regexp.MustCompile(`[^@ \t\r\n]+@[^@ \t\r\n]+\.[^@ \t\r\n]+`)))
 	p.Use(use.ValidateUniquenessOf("email"))
@@ -100,6 +182,40 @@ func (p *PlaceSchema) Init() {
 	//
 	//	weasel.UseHasMany(p, Person)
 	p.Use(use.HasMany[*PlaceSchema](Person))
+	p.Use(use.HasMany[*PlaceSchema](Comment))
+}
+
+func (c *CommentSchema) Init() {
+	c.Use(use.BelongsToAny[*CommentSchema]("commentable"))
+}
+
+func (m *MessageSchema) Init() {
+	m.Use(use.BelongsToField[*MessageSchema]("Sender", Person))
+	m.Use(use.BelongsToField[*MessageSchema]("Recipient", Person))
+}
+
+func (n *NoteSchema) Init() {
+	n.Use(use.ValidateUniquenessOf("body"))
+}
+
+type queryRecorder struct {
+	events []weasel.QueryEvent
+}
+
+func (r *queryRecorder) BeforeQuery(ctx context.Context, event *weasel.QueryEvent) context.Context {
+	return ctx
+}
+
+func (r *queryRecorder) AfterQuery(ctx context.Context, event *weasel.QueryEvent) {
+	r.events = append(r.events, *event)
+}
+
+func (r *queryRecorder) operations() []weasel.Operation {
+	ops := make([]weasel.Operation, 0, len(r.events))
+	for _, e := range r.events {
+		ops = append(ops, e.Operation)
+	}
+	return ops
 }
 
 type WeaselTestSuite struct {
@@ -111,6 +227,15 @@ func (s *WeaselTestSuite) SetupTest() {
 	s.assert = assert.New(s.T())
 	conn.DB.MustExec(schema)
 	Person.CreateGroup("FromUS", weasel.Eq{"place_id": 1}) // Basically the same as BelongsTo now, just a different format
+	Person.Scope("InCountry", func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+		return q.Joins("Place").Where(weasel.Eq{"place.country": args[0]})
+	})
+	Person.Scope("Named", func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+		return q.Where(weasel.Eq{"person.first_name": args[0]})
+	})
+	Person.Scope("Limited", func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+		return q.Limit(uint64(args[0].(int)))
+	})
 }
 
 func (s *WeaselTestSuite) TestInsert() {
@@ -158,6 +283,33 @@ func (s *WeaselTestSuite) TestGetSet() {
 	s.assert.Equal("Pizza", p.FirstName)
 }
 
+func (s *WeaselTestSuite) TestSaveChangedFields() {
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	other, err := Person.Find(1)
+	s.assert.Nil(err)
+
+	other.LastName = "Smith"
+	s.assert.Nil(other.Save())
+	p.FirstName = "Johnny"
+	s.assert.Nil(p.Save())
+	p, err = Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Johnny", p.FirstName)
+	s.assert.Equal("Smith", p.LastName)
+
+	// Changed fields are still written, whether they were set or assigned, including zero values
+	p.Set("email", "johnny@doe.com")
+	p.PlaceId = 0
+	s.assert.Nil(p.Save())
+	p, err = Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("johnny@doe.com", p.Email)
+	s.assert.Equal(0, p.PlaceId)
+	s.assert.False(p.IsDirty())
+	s.assert.Nil(p.Save())
+}
+
 func (s *WeaselTestSuite) TestSave() {
 	p, err := Person.Find(1)
 	s.assert.Nil(err)
@@ -360,6 +512,1241 @@ func (s *WeaselTestSuite) TestMap() {
 	s.assert.Equal("John", m["first_name"])
 }
 
+func (s *WeaselTestSuite) TestLocking() {
+	p, err := Person.ForUpdate().SkipLocked().Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("John", p.FirstName)
+
+	people, err := Person.All().ForShare().NoWait().Exec()
+	s.assert.Nil(err)
+	s.assert.GreaterOrEqual(len(people), 2)
+
+	place, err := weasel.Select([]string{"*"}, Place).Where(weasel.Eq{"id": 1}).ForUpdate().Exec()
+	s.assert.Nil(err)
+	s.assert.Equal(1, place.Id)
+}
+
+func (s *WeaselTestSuite) TestReload() {
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.False(p.IsDirty())
+
+	p.FirstName = "Pizza"
+	s.assert.True(p.IsDirty())
+	s.assert.Equal([]string{"first_name"}, p.DirtyFields())
+
+	conn.DB.MustExec("UPDATE person SET last_name = 'Smith' WHERE id = 1")
+	err = p.Reload()
+	s.assert.Nil(err)
+	s.assert.Equal("John", p.FirstName)
+	s.assert.Equal("Smith", p.LastName)
+	s.assert.Equal("world", p.Hello)
+	s.assert.False(p.IsDirty())
+
+	conn.DB.MustExec("DELETE FROM person WHERE id = 1")
+	s.assert.ErrorIs(p.Reload(), weasel.ErrNotFound)
+}
+
+func (s *WeaselTestSuite) TestFindOrCreate() {
+	p, err := Person.FindOrCreateBy(map[string]any{"email": "john@doe.com"}, &PersonSchema{FirstName: "Someone"})
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.Id)
+
+	p, err = Person.FindOrCreateBy(map[string]any{"email": "new@person.com"}, &PersonSchema{
+		FirstName: "New",
+		LastName:  "Person",
+		PlaceId:   1,
+	})
+	s.assert.Nil(err)
+	s.assert.NotEqual(0, p.Id)
+	s.assert.Equal("new@person.com", p.Email)
+}
+
+func (s *WeaselTestSuite) TestCreateOrFind() {
+	p, err := Place.CreateOrFind(map[string]any{"city": "Chicago"}, &PlaceSchema{Country: "Canada", Telcode: 1})
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.Id)
+	s.assert.Equal("United States of America", p.Country)
+
+	p, err = Place.CreateOrFind(map[string]any{"city": "Toronto"}, &PlaceSchema{Country: "Canada", Telcode: 1})
+	s.assert.Nil(err)
+	s.assert.NotEqual(1, p.Id)
+	s.assert.Equal("Toronto", p.City)
+
+	// Failed validations are not taken for an existing row
+	_, err = Person.CreateOrFind(map[string]any{"email": "jane@doe.net"}, &PersonSchema{
+		FirstName: "Janet",
+		LastName:  "Doe",
+		PlaceId:   1,
+	})
+	s.assert.EqualError(err, "document is invalid")
+
+	// The violation does not abort the enclosing transaction
+	err = conn.Transaction(func(tx weasel.Connection) error {
+		p, err := Place.Using(tx).CreateOrFind(map[string]any{"city": "Chicago"}, &PlaceSchema{Country: "Canada", Telcode: 1})
+		s.assert.Nil(err)
+		s.assert.Equal(1, p.Id)
+		p, err = Place.Using(tx).Find(1)
+		s.assert.Nil(err)
+		s.assert.Equal("Chicago", p.City)
+		return errors.New("rollback")
+	})
+	s.assert.EqualError(err, "rollback")
+}
+
+func (s *WeaselTestSuite) TestFirstOrInit() {
+	p, err := Person.FromGroup("FromUS").FirstOrInit(map[string]any{"first_name": "John"})
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.Id)
+
+	p, err = Person.FromGroup("FromUS").FirstOrInit(map[string]any{"first_name": "Nobody"})
+	s.assert.Nil(err)
+	s.assert.Equal(0, p.Id)
+	s.assert.Equal("Nobody", p.FirstName)
+	s.assert.Equal(1, p.PlaceId)
+}
+
+func (s *WeaselTestSuite) TestGroupCreate() {
+	p, err := Person.FromGroup("FromUS").Create(&PersonSchema{
+		FirstName: "Grouped",
+		LastName:  "Person",
+		Email:     "grouped@person.com",
+	})
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.PlaceId)
+
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	p, err = place.People().Create(&PersonSchema{
+		FirstName: "Placed",
+		LastName:  "Person",
+		Email:     "placed@person.com",
+	})
+	s.assert.Nil(err)
+	s.assert.Equal(place.Id, p.PlaceId)
+}
+
+func (s *WeaselTestSuite) TestHasManyThroughCreate() {
+	one, err := Person.Find(1)
+	s.assert.Nil(err)
+
+	friend, err := one.Friends().Create(&PersonSchema{
+		FirstName: "New",
+		LastName:  "Friend",
+		Email:     "new@friend.com",
+		PlaceId:   1,
+	})
+	s.assert.Nil(err)
+
+	ex, err := one.Friends().Exists(friend.Id)
+	s.assert.Nil(err)
+	s.assert.True(ex)
+
+	count, err := one.Friends().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(2, count)
+
+	friends, err := one.Friends().All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(friends, 2)
+}
+
+func (s *WeaselTestSuite) TestHasManyThroughManage() {
+	one, err := Person.Find(1)
+	s.assert.Nil(err)
+	two, err := Person.Find(2)
+	s.assert.Nil(err)
+	three, err := Person.Create(&PersonSchema{
+		FirstName: "Third",
+		LastName:  "Person",
+		Email:     "third@person.com",
+		PlaceId:   1,
+	})
+	s.assert.Nil(err)
+
+	has, err := one.Friends().Has(three)
+	s.assert.Nil(err)
+	s.assert.False(has)
+
+	err = one.Friends().AddWith(map[string]any{"note": "met at work"}, three)
+	s.assert.Nil(err)
+	has, err = one.Friends().Has(three)
+	s.assert.Nil(err)
+	s.assert.True(has)
+
+	err = one.Friends().Add(three, three, two)
+	s.assert.Nil(err)
+	count, err := one.Friends().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(2, count)
+
+	err = one.Friends().Remove(two)
+	s.assert.Nil(err)
+	count, err = one.Friends().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	err = one.Friends().Replace(two, three)
+	s.assert.Nil(err)
+	count, err = one.Friends().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(2, count)
+
+	err = one.Friends().Clear()
+	s.assert.Nil(err)
+	count, err = one.Friends().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(0, count)
+
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	s.assert.ErrorIs(place.People().Add(one), weasel.ErrNotThrough)
+}
+
+func (s *WeaselTestSuite) TestPolymorphic() {
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+
+	c, err := place.Comments().Create(&CommentSchema{Body: "Nice city"})
+	s.assert.Nil(err)
+	s.assert.Equal("place", c.CommentableType)
+	s.assert.Equal(place.Id, c.CommentableId)
+
+	parent, err := c.Commentable()
+	s.assert.Nil(err)
+	s.assert.Equal(place.Id, parent.(*PlaceSchema).Id)
+
+	count, err := place.Comments().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	count, err = p.Comments().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(0, count)
+}
+
+func (s *WeaselTestSuite) TestRelationsToSameTable() {
+	m, err := Message.Find(1)
+	s.assert.Nil(err)
+
+	sender, err := m.Sender()
+	s.assert.Nil(err)
+	s.assert.Equal("John", sender.FirstName)
+
+	recipient, err := m.Recipient()
+	s.assert.Nil(err)
+	s.assert.Equal("Jane", recipient.FirstName)
+
+	sent, err := sender.Sent().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, sent)
+
+	received, err := sender.Received().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(0, received)
+
+	s.assert.PanicsWithValue("use.BelongsToField: weasel_test.MessageSchema has no belongsto field Author", func() {
+		use.BelongsToField[*MessageSchema]("Author", Person)
+	})
+	s.assert.PanicsWithValue("use.HasManyField: weasel_test.PersonSchema has no hasmany field Place", func() {
+		use.HasManyField[*PersonSchema]("Place", Place)
+	})
+}
+
+type SenderSchema struct {
+	weasel.Document[*SenderSchema]
+	Id        int                           `db:"id" pk:"" type:"serial"`
+	FirstName string                        `db:"first_name" type:"text"`
+	FirstSent weasel.HasOne[*MessageSchema] `hasone:"message" fk:"sender_id"`
+}
+
+func (p *SenderSchema) Init() {
+	p.Use(use.HasOneField[*SenderSchema]("FirstSent", Message))
+}
+
+func (s *WeaselTestSuite) TestHasOne() {
+	other := weasel.Connect("postgres", weasel.Opts{
+		User:     "ztcollazo",
+		Database: "postgres",
+	})
+	defer other.Close()
+	Sender := weasel.Create(other, &SenderSchema{}, "person")
+
+	john, err := Sender.Find(1)
+	s.assert.Nil(err)
+	m, err := john.FirstSent()
+	s.assert.Nil(err)
+	s.assert.Equal("Hi Jane", m.Body)
+
+	jane, err := Sender.Find(2)
+	s.assert.Nil(err)
+	_, err = jane.FirstSent()
+	s.assert.ErrorIs(err, sql.ErrNoRows)
+}
+
+type OrphanSchema struct {
+	weasel.Document[*OrphanSchema]
+	Id      int                            `db:"id" pk:"" type:"serial"`
+	PlaceId int                            `db:"place_id" type:"integer"`
+	Place   weasel.BelongsTo[*PlaceSchema] `belongsto:"place"`
+}
+
+func (s *WeaselTestSuite) TestRegistry() {
+	s.assert.Nil(conn.Registry().Verify())
+	s.assert.Contains(conn.Registry().Models(), "person")
+
+	// Sent is not wired in Init; it comes from the registry.
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.NotNil(p.Sent)
+
+	other := weasel.Connect("postgres", weasel.Opts{
+		User:     "ztcollazo",
+		Database: "postgres",
+	})
+	defer other.Close()
+	weasel.Create(other, &OrphanSchema{}, "orphan")
+	s.assert.ErrorContains(other.Registry().Verify(), `orphan.Place: no model registered for table "place"`)
+}
+
+func (s *WeaselTestSuite) TestDependent() {
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	var restrict *weasel.RestrictError
+	s.assert.ErrorAs(place.Delete(), &restrict)
+	s.assert.Equal("People", restrict.Relation)
+	s.assert.Equal(2, restrict.Count)
+
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	_, err = p.Comments().Create(&CommentSchema{Body: "Hello"})
+	s.assert.Nil(err)
+
+	err = p.Delete()
+	s.assert.Nil(err)
+
+	var cnt int
+	conn.DB.Get(&cnt, "SELECT COUNT(*) FROM comment WHERE commentable_type = 'person' AND commentable_id = 1")
+	s.assert.Equal(0, cnt)
+	conn.DB.Get(&cnt, "SELECT COUNT(*) FROM friends WHERE friender = 1")
+	s.assert.Equal(0, cnt)
+	conn.DB.Get(&cnt, "SELECT COUNT(*) FROM message WHERE sender_id IS NULL")
+	s.assert.Equal(1, cnt)
+}
+
+type CascadeSchema struct {
+	weasel.Document[*CascadeSchema]
+	Id     int                           `db:"id" pk:"" type:"serial"`
+	People weasel.HasMany[*PersonSchema] `hasmany:"person" dependent:"cascade"`
+}
+
+func (s *WeaselTestSuite) TestDependentTag() {
+	s.assert.PanicsWithError(`unknown dependent action "cascade" on cascade.People`, func() {
+		weasel.Create(conn, &CascadeSchema{}, "cascade")
+	})
+}
+
+func (s *WeaselTestSuite) TestNestedWrites() {
+	place := &PlaceSchema{Country: "Canada", City: "Toronto", Telcode: 1}
+	place.Attach("People", &PersonSchema{FirstName: "Jean", LastName: "Tremblay", Email: "jean@tremblay.ca"})
+	created, err := Place.Create(place)
+	s.assert.Nil(err)
+	count, err := created.People().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	p := &PersonSchema{FirstName: "Ana", LastName: "Silva", Email: "ana@silva.br"}
+	p.Attach("Place", &PlaceSchema{Country: "Brazil", City: "Rio de Janeiro", Telcode: 55})
+	p, err = Person.Create(p)
+	s.assert.Nil(err)
+	s.assert.NotEqual(0, p.PlaceId)
+	parent, err := p.Place()
+	s.assert.Nil(err)
+	s.assert.Equal("Brazil", parent.Country)
+
+	p.Attach("Comments", &CommentSchema{Body: "Olá"})
+	err = p.Save()
+	s.assert.Nil(err)
+	count, err = p.Comments().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	// Attached documents that were already saved are validated before they are saved again
+	john, err := Person.Find(1)
+	s.assert.Nil(err)
+	john.Email = ""
+	created.Attach("People", john)
+	s.assert.EqualError(created.Save(), "document is invalid")
+	john, err = Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("john@doe.com", john.Email)
+	s.assert.Equal(1, john.PlaceId)
+}
+
+func (s *WeaselTestSuite) TestJoins() {
+	people, err := Person.All().Joins("Place").Where(weasel.Eq{"place.country": "United States of America"}).Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 2)
+
+	friends, err := Person.All().Joins("Friends").Where(weasel.Eq{"friends_person.first_name": "Jane"}).Exec()
+	s.assert.Nil(err)
+	s.assert.Len(friends, 1)
+	s.assert.Equal("John", friends[0].FirstName)
+
+	_, err = Person.All().Joins("Nothing").Exec()
+	s.assert.NotNil(err)
+}
+
+func (s *WeaselTestSuite) TestWhereHas() {
+	people, err := Person.All().WhereHas("Friends").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 1)
+	s.assert.Equal("John", people[0].FirstName)
+
+	people, err = Person.All().WhereHas("Friends", func(q weasel.SelectBuilder) weasel.SelectBuilder {
+		return q.Where(weasel.Eq{"friends_person.first_name": "Nobody"})
+	}).Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 0)
+
+	people, err = Person.All().WhereDoesntHave("Friends").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 1)
+	s.assert.Equal("Jane", people[0].FirstName)
+
+	places, err := Place.All().WhereHas("People", func(q weasel.SelectBuilder) weasel.SelectBuilder {
+		return q.Where(weasel.Eq{"person.first_name": "Jane"})
+	}).Exec()
+	s.assert.Nil(err)
+	s.assert.Len(places, 1)
+}
+
+func (s *WeaselTestSuite) TestCounterCache() {
+	err := Person.ResetCounters()
+	s.assert.Nil(err)
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(2, place.PeopleCount)
+
+	p, err := Person.Create(&PersonSchema{
+		FirstName: "Counted",
+		LastName:  "Person",
+		Email:     "counted@person.com",
+		PlaceId:   1,
+	})
+	s.assert.Nil(err)
+	s.assert.Nil(place.Reload())
+	s.assert.Equal(3, place.PeopleCount)
+
+	other, err := Place.Create(&PlaceSchema{Country: "Mexico", City: "Monterrey", Telcode: 52})
+	s.assert.Nil(err)
+	p.PlaceId = other.Id
+	s.assert.Nil(p.Save())
+	s.assert.Nil(place.Reload())
+	s.assert.Equal(2, place.PeopleCount)
+	s.assert.Nil(other.Reload())
+	s.assert.Equal(1, other.PeopleCount)
+
+	s.assert.Nil(p.Delete())
+	s.assert.Nil(other.Reload())
+	s.assert.Equal(0, other.PeopleCount)
+
+	// ResetCounters runs in the caller's transaction, and sees its uncommitted rows
+	rollback := errors.New("rollback")
+	err = conn.Transaction(func(tx weasel.Connection) error {
+		if _, err := tx.Builder.Delete("person").Where(weasel.Eq{"place_id": 1}).Exec(); err != nil {
+			return err
+		}
+		s.assert.Nil(Person.Using(tx).ResetCounters("Place"))
+		inTx, err := Place.Using(tx).Find(1)
+		s.assert.Nil(err)
+		s.assert.Equal(0, inTx.PeopleCount)
+		return rollback
+	})
+	s.assert.ErrorIs(err, rollback)
+	s.assert.Nil(place.Reload())
+	s.assert.Equal(2, place.PeopleCount)
+}
+
+func (s *WeaselTestSuite) TestScopes() {
+	people, err := Person.Scoped("InCountry", "United States of America").All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 2)
+
+	p, err := Person.Scoped("FromUS").Scoped("Named", "Jane").First()
+	s.assert.Nil(err)
+	s.assert.Equal(2, p.Id)
+
+	count, err := Person.Scoped("FromUS").Scoped("Limited", 1).Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	_, err = Person.Scoped("Named", "Jane").Find(1)
+	s.assert.NotNil(err)
+
+	_, err = Person.Scoped("Nothing").All().Exec()
+	s.assert.NotNil(err)
+
+	// The Nth family inverts a scope's order, and counts within a scope's limit
+	Person.Scope("Newest", func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+		return q.OrderBy("person.id DESC")
+	})
+	p, err = Person.Scoped("Newest").First()
+	s.assert.Nil(err)
+	s.assert.Equal(2, p.Id)
+	p, err = Person.Scoped("Newest").Last()
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.Id)
+	people, err = Person.Scoped("Newest").All().Exec()
+	s.assert.Nil(err)
+	s.assert.Equal(2, people[0].Id)
+
+	p, err = Person.Scoped("Limited", 1).Last()
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.Id)
+	p, err = Person.Scoped("Newest").Scoped("Limited", 1).Last()
+	s.assert.Nil(err)
+	s.assert.Equal(2, p.Id)
+	_, err = Person.Scoped("Limited", 1).Second()
+	s.assert.ErrorIs(err, sql.ErrNoRows)
+}
+
+func (s *WeaselTestSuite) TestOrderBy() {
+	newest := Person.OrderBy("person.id DESC")
+	s.assert.Equal("person.id ASC", Person.GetOrder())
+	p, err := newest.First()
+	s.assert.Nil(err)
+	s.assert.Equal(2, p.Id)
+
+	// The deprecated Order changes the group in place
+	group := Person.Scoped("FromUS")
+	group.Order("person.id DESC")
+	s.assert.Equal("person.id DESC", group.GetOrder())
+	p, err = group.First()
+	s.assert.Nil(err)
+	s.assert.Equal(2, p.Id)
+	s.assert.Equal("person.id ASC", Person.FromGroup("FromUS").GetOrder())
+}
+
+func (s *WeaselTestSuite) TestDefaultScope() {
+	Comment.DefaultScope(weasel.Eq{"comment.hidden": false}, "comment.id DESC")
+	defer Comment.DefaultScope(nil, "")
+
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	first, err := place.Comments().Create(&CommentSchema{Body: "First"})
+	s.assert.Nil(err)
+	s.assert.False(first.Hidden)
+	second, err := place.Comments().Create(&CommentSchema{Body: "Second"})
+	s.assert.Nil(err)
+	second.Hidden = true
+	s.assert.Nil(second.Save())
+
+	comments, err := place.Comments().All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(comments, 1)
+
+	all, err := Comment.Unscoped().All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(all, 2)
+	s.assert.Equal(second.Id, all[1].Id)
+
+	_, err = Comment.Find(second.Id)
+	s.assert.NotNil(err)
+	_, err = Comment.Unscoped().Find(second.Id)
+	s.assert.Nil(err)
+
+	Comment.DefaultScope(nil, "comment.id DESC")
+	latest, err := Comment.First()
+	s.assert.Nil(err)
+	s.assert.Equal(second.Id, latest.Id)
+}
+
+func (s *WeaselTestSuite) TestTenantColumn() {
+	_, err := Note.All().Exec()
+	s.assert.ErrorIs(err, weasel.ErrNoTenant)
+	_, err = Note.Create(&NoteSchema{Body: "Nobody's"})
+	s.assert.ErrorIs(err, weasel.ErrNoTenant)
+
+	acme := Note.ForTenant(1)
+	note, err := acme.Create(&NoteSchema{Body: "Hello"})
+	s.assert.Nil(err)
+	s.assert.Equal(1, note.TenantId)
+	_, err = Note.ForTenant(2).Create(&NoteSchema{Body: "Hello"})
+	s.assert.Nil(err)
+	_, err = acme.Create(&NoteSchema{Body: "Hello"})
+	s.assert.NotNil(err)
+
+	notes, err := acme.All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(notes, 1)
+	_, err = Note.ForTenant(2).Find(note.Id)
+	s.assert.NotNil(err)
+
+	count, err := Note.AllTenants().Count()
+	s.assert.Nil(err)
+	s.assert.Equal(2, count)
+
+	ctx := weasel.ContextWithTenant(context.Background(), 2)
+	count, err = Note.WithContext(ctx).Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+
+	note.Body = "Changed"
+	s.assert.Nil(note.Save())
+	s.assert.Nil(note.Reload())
+	s.assert.Equal("Changed", note.Body)
+
+	// Documents created in a transaction are validated in it, and leave it once it is over
+	var draft *NoteSchema
+	err = conn.Transaction(func(tx weasel.Connection) error {
+		notes := Note.Using(tx).ForTenant(3)
+		draft, err = notes.Create(&NoteSchema{Body: "Draft"})
+		s.assert.Nil(err)
+		_, err = notes.Create(&NoteSchema{Body: "Draft"})
+		s.assert.NotNil(err)
+		return nil
+	})
+	s.assert.Nil(err)
+	draft.Body = "Final"
+	s.assert.Nil(draft.Save())
+}
+
+type PinSchema struct {
+	weasel.Document[*PinSchema]
+	Id       int                            `db:"id" pk:"" type:"serial"`
+	TenantId int                            `db:"tenant_id" type:"integer" tenant:""`
+	Body     string                         `db:"body" type:"text"`
+	PlaceId  int                            `db:"place_id" type:"integer"`
+	Place    weasel.BelongsTo[*PlaceSchema] `belongsto:"place" key:"place_id" countercache:"people_count"`
+}
+
+func (s *WeaselTestSuite) TestTenantCounters() {
+	other := weasel.Connect("postgres", weasel.Opts{
+		User:     "ztcollazo",
+		Database: "postgres",
+	})
+	defer other.Close()
+	Pin := weasel.Create(other, &PinSchema{}, "note")
+	_, err := Pin.ForTenant(1).Create(&PinSchema{Body: "Mine", PlaceId: 1})
+	s.assert.Nil(err)
+	_, err = Pin.ForTenant(2).Create(&PinSchema{Body: "Theirs", PlaceId: 1})
+	s.assert.Nil(err)
+
+	s.assert.ErrorIs(Pin.ResetCounters(), weasel.ErrNoTenant)
+	s.assert.Nil(Pin.ForTenant(1).ResetCounters())
+	place, err := Place.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(1, place.PeopleCount)
+	s.assert.Nil(Pin.AllTenants().ResetCounters())
+	s.assert.Nil(place.Reload())
+	s.assert.Equal(2, place.PeopleCount)
+}
+
+type BoardSchema struct {
+	weasel.Document[*BoardSchema]
+	Id   int                        `db:"id" pk:"" type:"serial"`
+	City string                     `db:"city" type:"text"`
+	Pins weasel.HasMany[*PinSchema] `hasmany:"note" fk:"place_id" key:"id"`
+}
+
+func (s *WeaselTestSuite) TestTenantJoins() {
+	other := weasel.Connect("postgres", weasel.Opts{
+		User:     "ztcollazo",
+		Database: "postgres",
+	})
+	defer other.Close()
+	Pin := weasel.Create(other, &PinSchema{}, "note")
+	Board := weasel.Create(other, &BoardSchema{}, "place")
+	_, err := Pin.ForTenant(2).Create(&PinSchema{Body: "Theirs", PlaceId: 1})
+	s.assert.Nil(err)
+
+	mine, theirs := Board.Using(other.ForTenant(1)), Board.Using(other.ForTenant(2))
+	boards, err := mine.All().WhereHas("Pins").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 0)
+	boards, err = theirs.All().WhereHas("Pins").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 1)
+	boards, err = mine.All().WhereDoesntHave("Pins").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 1)
+
+	boards, err = mine.All().Joins("Pins").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 0)
+	boards, err = theirs.All().Joins("Pins").Where(weasel.Eq{"note.body": "Theirs"}).Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 1)
+
+	_, err = Board.All().WhereHas("Pins").Exec()
+	s.assert.ErrorIs(err, weasel.ErrNoTenant)
+	boards, err = Board.Using(other.AllTenants()).All().Joins("Pins").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(boards, 1)
+}
+
+func (s *WeaselTestSuite) TestTenantSchema() {
+	tenants := conn.SchemaPerTenant(func(id any) string {
+		return fmt.Sprint("tenant_", id)
+	})
+	s.assert.Equal("tenant_a.place AS place", tenants.ForTenant("a").Table("place"))
+	s.assert.Equal("place", tenants.Table("place"))
+
+	places := Place.Using(tenants.ForTenant("a"))
+	count, err := places.Count()
+	s.assert.Nil(err)
+	s.assert.Equal(0, count)
+
+	place, err := places.Create(&PlaceSchema{Country: "Canada", City: "Toronto", Telcode: 1})
+	s.assert.Nil(err)
+	place.City = "Montreal"
+	s.assert.Nil(place.Save())
+
+	found, err := places.Find(place.Id)
+	s.assert.Nil(err)
+	s.assert.Equal("Montreal", found.City)
+
+	count, err = Place.Count()
+	s.assert.Nil(err)
+	s.assert.Equal(1, count)
+}
+
+func (s *WeaselTestSuite) TestReplicas() {
+	opts := weasel.Opts{User: "ztcollazo", Database: "postgres"}
+	broken := weasel.Opts{User: "ztcollazo", Database: "postgres", Port: 1, ConnectTimeout: 1}
+	rconn := weasel.Connect("postgres", opts, broken, opts)
+	defer rconn.Close()
+	rec := &queryRecorder{}
+	rconn.AddHook(rec)
+	places := weasel.Create(rconn, &PlaceSchema{}, "place")
+
+	for i := 0; i < 4; i++ {
+		count, err := places.Count()
+		s.assert.Nil(err)
+		s.assert.Equal(1, count)
+	}
+
+	rconn.SetStickyWindow(time.Minute)
+	place, err := places.Create(&PlaceSchema{Country: "Canada", City: "Toronto", Telcode: 1})
+	s.assert.Nil(err)
+	found, err := places.Find(place.Id)
+	s.assert.Nil(err)
+	s.assert.Equal("Toronto", found.City)
+
+	locked, err := places.UsePrimary().ForUpdate().Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(1, locked.Id)
+
+	// Reads from a replica right after a write are not cached, since it may not have the write yet
+	rconn.SetStickyWindow(0)
+	places.Cache(time.Minute, nil)
+	found.City = "Ottawa"
+	s.assert.Nil(found.Save())
+	events := len(rec.events)
+	for i := 0; i < 2; i++ {
+		_, err = places.Find(place.Id)
+		s.assert.Nil(err)
+	}
+	s.assert.Len(rec.events, events+2)
+	for i := 0; i < 2; i++ {
+		_, err = places.UsePrimary().Find(place.Id)
+		s.assert.Nil(err)
+	}
+	s.assert.Len(rec.events, events+3)
+}
+
+func (s *WeaselTestSuite) TestQueryHooks() {
+	hconn := weasel.Connect("postgres", weasel.Opts{User: "ztcollazo", Database: "postgres"})
+	defer hconn.Close()
+	rec := &queryRecorder{}
+	hconn.AddHook(rec)
+	var buf bytes.Buffer
+	hconn.AddHook(&weasel.Logger{
+		Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+		Level:         slog.LevelInfo,
+		SlowThreshold: time.Nanosecond,
+		RedactArgs:    true,
+	})
+	people := weasel.Create(hconn, &PersonSchema{}, "person")
+	// Deleting a person destroys their comments, which needs the comment model.
+	weasel.Create(hconn, &CommentSchema{}, "comment")
+
+	p, err := people.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("person", rec.events[0].Model)
+	s.assert.Equal(weasel.OpFind, rec.events[0].Operation)
+	s.assert.Contains(rec.events[0].SQL, "FROM person")
+	s.assert.Equal(int64(1), rec.events[0].Rows)
+
+	_, err = people.All().Exec()
+	s.assert.Nil(err)
+	_, err = people.Count()
+	s.assert.Nil(err)
+	p.FirstName = "Johnny"
+	s.assert.Nil(p.Save())
+	bob, err := people.Create(&PersonSchema{FirstName: "Bob", LastName: "Smith", Email: "bob@smith.com", PlaceId: 1})
+	s.assert.Nil(err)
+	s.assert.Nil(bob.Delete())
+
+	ops := rec.operations()
+	for _, op := range []weasel.Operation{weasel.OpAll, weasel.OpCount, weasel.OpSave, weasel.OpValidate, weasel.OpInsert, weasel.OpDelete} {
+		s.assert.Contains(ops, op)
+	}
+
+	_, err = people.All().Where("nope = 1").Exec()
+	s.assert.NotNil(err)
+	s.assert.NotNil(rec.events[len(rec.events)-1].Err)
+
+	// Rows that are never scanned are reported too
+	events := len(rec.events)
+	hconn.Builder.Select("id").From("person").QueryRow()
+	s.assert.Len(rec.events, events+1)
+
+	s.assert.Contains(buf.String(), "slow query")
+	s.assert.Contains(buf.String(), "query failed")
+	s.assert.NotContains(buf.String(), "bob@smith.com")
+}
+
+func (s *WeaselTestSuite) TestMetrics() {
+	mconn := weasel.Connect("postgres", weasel.Opts{User: "ztcollazo", Database: "postgres"})
+	defer mconn.Close()
+	// expvar names cannot be unpublished, so each run (go test -count) publishes its own
+	name := fmt.Sprint("weasel_test_", time.Now().UnixNano())
+	metrics := weasel.NewExpvarMetrics(name)
+	s.assert.Same(metrics.Map(), weasel.NewExpvarMetrics(name).Map())
+	mconn.AddHook(weasel.MetricsHook(metrics))
+	places := weasel.Create(mconn, &PlaceSchema{}, "place")
+
+	for i := 0; i < 3; i++ {
+		_, err := places.Find(1)
+		s.assert.Nil(err)
+	}
+	_, err := places.All().Exec()
+	s.assert.Nil(err)
+	_, err = places.Find(42)
+	s.assert.NotNil(err)
+
+	find := metrics.Map().Get("place.find").(*expvar.Map)
+	s.assert.Equal("4", find.Get("queries").String())
+	s.assert.Equal("1", find.Get("errors").String())
+	s.assert.Equal("3", find.Get("rows").(*expvar.Map).Get("sum").String())
+	s.assert.Equal("4", find.Get("latency").(*expvar.Map).Get("le_inf").String())
+
+	all := metrics.Map().Get("place.all").(*expvar.Map)
+	s.assert.Equal("1", all.Get("queries").String())
+	s.assert.Equal("1", all.Get("rows").(*expvar.Map).Get("le_1").String())
+}
+
+func (s *WeaselTestSuite) TestTracing() {
+	tconn := weasel.Connect("postgres", weasel.Opts{User: "ztcollazo", Database: "postgres"})
+	defer tconn.Close()
+	rec := &weasel.SpanRecorder{}
+	tconn.AddHook(weasel.TracingHook(rec))
+	people := weasel.Create(tconn, &PersonSchema{}, "person")
+	// Deleting a person destroys their comments, which needs the comment model.
+	weasel.Create(tconn, &CommentSchema{}, "comment")
+
+	ctx, request := rec.Start(context.Background(), "request", nil)
+	p, err := people.WithContext(ctx).Find(1)
+	s.assert.Nil(err)
+	p.FirstName = "Johnny"
+	s.assert.Nil(p.Save())
+	_, err = people.WithContext(ctx).All().Where("first_name = 'Jane'").Exec()
+	s.assert.Nil(err)
+	bob, err := people.WithContext(ctx).Create(&PersonSchema{FirstName: "Bob", LastName: "Smith", Email: "bob@smith.com", PlaceId: 1})
+	s.assert.Nil(err)
+	s.assert.Nil(bob.Delete())
+	request.End()
+
+	spans := rec.Spans()
+	byName := make(map[string]weasel.RecordedSpan)
+	for _, span := range spans {
+		s.assert.True(span.Ended, span.Name)
+		if _, ok := byName[span.Name]; !ok {
+			byName[span.Name] = span
+		}
+	}
+	// Operations are children of the caller's span, and their queries are children of theirs
+	for op, queries := range map[string][]string{
+		"person.Find":   {"find person", "validate person"},
+		"person.Save":   {"save person"},
+		"person.All":    {"all person"},
+		"person.Create": {"insert person"},
+		"person.Delete": {"delete person", "comment.All"},
+	} {
+		s.assert.Equal(byName["request"].ID, byName[op].ParentID, op)
+		for _, query := range queries {
+			s.assert.Equal(byName[op].ID, byName[query].ParentID, query)
+		}
+	}
+	// Operations run by others, such as dependent actions, are nested too
+	s.assert.Equal(byName["comment.All"].ID, byName["all comment"].ParentID)
+	s.assert.Equal("Save", byName["person.Save"].Attributes["weasel.operation"])
+	s.assert.Equal("person", byName["person.Save"].Attributes["db.sql.table"])
+	for _, span := range spans[1:] {
+		s.assert.NotEqual(0, span.ParentID, span.Name)
+	}
+
+	find := byName["find person"]
+	s.assert.Equal("postgresql", find.Attributes["db.system"])
+	s.assert.Equal("person", find.Attributes["db.sql.table"])
+	s.assert.Equal(int64(1), find.Attributes["db.rows"])
+
+	all := byName["all person"]
+	s.assert.Contains(all.Attributes["db.statement"], "first_name = ?")
+	s.assert.NotContains(all.Attributes["db.statement"], "Jane")
+}
+
+func (s *WeaselTestSuite) TestPartialLoad() {
+	conn.DB.MustExec("ALTER TABLE person ADD COLUMN nickname text")
+	_, err := Person.Find(1)
+	s.assert.Nil(err)
+
+	p, err := Person.Select("first_name").Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("John", p.FirstName)
+	s.assert.Equal("", p.Email)
+	s.assert.True(p.IsLoaded("first_name"))
+	s.assert.False(p.IsLoaded("email"))
+	p.FirstName = "Johnny"
+	s.assert.Nil(p.Save())
+	s.assert.Nil(p.Reload())
+	s.assert.Equal("Johnny", p.FirstName)
+	s.assert.Equal("john@doe.com", p.Email)
+	s.assert.True(p.IsLoaded("email"))
+
+	people, err := Person.Omit("email", "last_name").All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 2)
+	s.assert.Equal("Jane", people[1].FirstName)
+	s.assert.Equal("", people[1].Email)
+	s.assert.False(people[1].IsLoaded("last_name"))
+
+	// Setting a skipped field to its zero value loads it, so that Save writes it
+	people[1].Set("last_name", "")
+	s.assert.True(people[1].IsLoaded("last_name"))
+	s.assert.Nil(people[1].Save())
+	s.assert.True(people[1].IsLoaded("last_name"))
+	s.assert.False(people[1].IsLoaded("email"))
+	jane, err := Person.Find(2)
+	s.assert.Nil(err)
+	s.assert.Equal("", jane.LastName)
+	s.assert.Equal("jane@doe.net", jane.Email)
+
+	_, err = Person.Select("nickname").All().Exec()
+	s.assert.EqualError(err, "person has no column nickname")
+}
+
+func (s *WeaselTestSuite) TestPreparedStatements() {
+	pconn := weasel.Connect("postgres", weasel.Opts{User: "ztcollazo", Database: "postgres"})
+	defer pconn.Close()
+	pconn.PrepareStatements(2)
+	places := weasel.Create(pconn, &PlaceSchema{}, "place")
+
+	for i := 0; i < 3; i++ {
+		p, err := places.Find(1)
+		s.assert.Nil(err)
+		s.assert.Equal(1, p.Id)
+	}
+	s.assert.Equal(1, pconn.PreparedStatements())
+
+	exists, err := places.Exists(1)
+	s.assert.Nil(err)
+	s.assert.True(exists)
+	all, err := places.All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(all, 1)
+	s.assert.Equal(2, pconn.PreparedStatements())
+
+	// Statements prepared outside of a transaction are reused in it
+	pconn.PrepareStatements(2)
+	_, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(1, pconn.PreparedStatements())
+	err = pconn.Transaction(func(tx weasel.Connection) error {
+		p, err := places.Using(tx).Find(1)
+		if err != nil {
+			return err
+		}
+		s.assert.Equal(1, pconn.PreparedStatements())
+		p.City = "Springfield"
+		return p.Save()
+	})
+	s.assert.Nil(err)
+	s.assert.Equal(2, pconn.PreparedStatements())
+	p, err := places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Springfield", p.City)
+	s.assert.Equal(2, pconn.PreparedStatements())
+
+	// Statements evicted while other queries run on them are closed once they are done
+	pconn.PrepareStatements(1)
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := places.Find(1); err != nil {
+					errs <- err
+				}
+				if _, err := places.Exists(1); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		s.assert.Nil(err)
+	}
+
+	pconn.PrepareStatements(0)
+	s.assert.Equal(0, pconn.PreparedStatements())
+	_, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(0, pconn.PreparedStatements())
+}
+
+func (s *WeaselTestSuite) TestResultCache() {
+	cconn := weasel.Connect("postgres", weasel.Opts{User: "ztcollazo", Database: "postgres"})
+	defer cconn.Close()
+	rec := &queryRecorder{}
+	cconn.AddHook(rec)
+	places := weasel.Create(cconn, &PlaceSchema{}, "place")
+	people := weasel.Create(cconn, &PersonSchema{}, "person")
+	places.Cache(time.Minute, nil)
+
+	p, err := places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Chicago", p.City)
+	_, err = places.Find(1)
+	s.assert.Nil(err)
+	for i := 0; i < 2; i++ {
+		all, err := places.All().Exec()
+		s.assert.Nil(err)
+		s.assert.Len(all, 1)
+	}
+	s.assert.Len(rec.events, 2)
+	_, err = places.NoCache().Find(1)
+	s.assert.Nil(err)
+	s.assert.Len(rec.events, 3)
+
+	p.City = "Boston"
+	s.assert.Nil(p.Save())
+	p, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Boston", p.City)
+	s.assert.Equal(0, p.PeopleCount)
+
+	_, err = people.Create(&PersonSchema{FirstName: "Ann", LastName: "Lee", Email: "ann@lee.com", PlaceId: 1})
+	s.assert.Nil(err)
+	p, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal(1, p.PeopleCount)
+
+	n, err := places.UpdateAll(map[string]any{"city": "Denver"})
+	s.assert.Nil(err)
+	s.assert.Equal(int64(1), n)
+	p, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Denver", p.City)
+
+	_, err = places.Create(&PlaceSchema{Country: "France", City: "Paris", Telcode: 33})
+	s.assert.Nil(err)
+	all, err := places.All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(all, 2)
+	places.CreateGroup("InFrance", weasel.Eq{"country": "France"})
+	n, err = places.FromGroup("InFrance").DeleteAll()
+	s.assert.Nil(err)
+	s.assert.Equal(int64(1), n)
+	all, err = places.All().Exec()
+	s.assert.Nil(err)
+	s.assert.Len(all, 1)
+
+	// Arguments are keyed by their value, not their address
+	one, also := 1, 1
+	_, err = places.FindBy("id", &one)
+	s.assert.Nil(err)
+	events := len(rec.events)
+	_, err = places.FindBy("id", &also)
+	s.assert.Nil(err)
+	s.assert.Len(rec.events, events)
+
+	// Writes in a transaction invalidate the cache once it is committed, after reads outside of it
+	err = cconn.Transaction(func(tx weasel.Connection) error {
+		inTx, err := places.Using(tx).Find(1)
+		if err != nil {
+			return err
+		}
+		inTx.City = "Austin"
+		if err := inTx.Save(); err != nil {
+			return err
+		}
+		outside, err := places.Find(1)
+		s.assert.Nil(err)
+		s.assert.Equal("Denver", outside.City)
+		return nil
+	})
+	s.assert.Nil(err)
+	p, err = places.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Austin", p.City)
+
+	// Changing the slices and pointers of a document does not change the cached result
+	refs := weasel.Create(cconn, &PlaceRefSchema{}, "place")
+	refs.Cache(time.Minute, nil)
+	ref, err := refs.Find(1)
+	s.assert.Nil(err)
+	*ref.City = "Changed"
+	ref.Country[0] = 'X'
+	events = len(rec.events)
+	for i := 0; i < 2; i++ {
+		ref, err = refs.Find(1)
+		s.assert.Nil(err)
+		s.assert.Equal("Austin", *ref.City)
+		s.assert.Equal("United States of America", string(ref.Country))
+		*ref.City = "Changed"
+		ref.Country = append(ref.Country[:0], 'X')
+	}
+	s.assert.Len(rec.events, events)
+}
+
+type PlaceRefSchema struct {
+	weasel.Document[*PlaceRefSchema]
+	Id      int     `db:"id" pk:"" type:"serial"`
+	City    *string `db:"city" type:"text"`
+	Country []byte  `db:"country" type:"text"`
+}
+
+func (s *WeaselTestSuite) TestSession() {
+	sess := weasel.NewSession()
+	ctx := weasel.ContextWithSession(context.Background(), sess)
+	john, err := Person.WithContext(ctx).Find(1)
+	s.assert.Nil(err)
+	again, err := Person.WithContext(ctx).FindBy("email", "john@doe.com")
+	s.assert.Nil(err)
+	s.assert.Same(john, again)
+	place, err := Place.WithContext(ctx).Find(1)
+	s.assert.Nil(err)
+	people, err := place.People().All().OrderBy("id").Exec()
+	s.assert.Nil(err)
+	s.assert.Len(people, 2)
+	s.assert.Same(john, people[0])
+	s.assert.Equal(3, sess.Len())
+
+	other, err := Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.NotSame(john, other)
+
+	john.Email = "john@doe.org"
+	place.City = "Boston"
+	again, err = Person.WithContext(ctx).Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("john@doe.org", again.Email)
+	s.assert.Nil(sess.Flush())
+	s.assert.False(john.IsDirty())
+	p, err := Person.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("john@doe.org", p.Email)
+	pl, err := Place.Find(1)
+	s.assert.Nil(err)
+	s.assert.Equal("Boston", pl.City)
+
+	s.assert.Nil(people[1].Delete())
+	s.assert.Equal(2, sess.Len())
+	sess.Clear()
+	s.assert.Equal(0, sess.Len())
+	s.assert.Nil(sess.Flush())
+
+	// A partial load is completed by a later full load
+	partial, err := Person.WithContext(ctx).Select("first_name").Find(1)
+	s.assert.Nil(err)
+	s.assert.False(partial.IsLoaded("email"))
+	full, err := Person.WithContext(ctx).Find(1)
+	s.assert.Nil(err)
+	s.assert.Same(partial, full)
+	s.assert.Equal("john@doe.org", partial.Email)
+	s.assert.True(partial.IsLoaded("email"))
+	s.assert.False(partial.IsDirty())
+
+	// Documents of different tenants are kept apart
+	note, err := Note.ForTenant(1).Create(&NoteSchema{Body: "Mine"})
+	s.assert.Nil(err)
+	mine, err := Note.ForTenant(1).WithContext(ctx).Find(note.Id)
+	s.assert.Nil(err)
+	unscoped, err := Note.AllTenants().WithContext(ctx).Find(note.Id)
+	s.assert.Nil(err)
+	s.assert.NotSame(mine, unscoped)
+	_, ok := unscoped.Conn().Tenant()
+	s.assert.False(ok)
+}
+
+// TestConcurrency uses a model and its groups from many goroutines; run it with -race.
+func (s *WeaselTestSuite) TestConcurrency() {
+	other := weasel.Connect("postgres", weasel.Opts{
+		User:     "ztcollazo",
+		Database: "postgres",
+	})
+	defer other.Close()
+	people := weasel.Create(other, &PersonSchema{}, "person")
+	people.CreateGroup("FromUS", weasel.Eq{"place_id": 1})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*20*4)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				name := fmt.Sprintf("Group%d_%d", i, j)
+				people.Set(name, j)
+				if people.Get(name) != j {
+					errs <- fmt.Errorf("%s was not set", name)
+				}
+				people.CreateGroup(name, weasel.Eq{"place_id": 1})
+				if _, err := people.FromGroup(name).Find(1); err != nil {
+					errs <- err
+				}
+				scope := fmt.Sprintf("Scope%d_%d", i, j)
+				people.Scope(scope, func(q weasel.SelectManyQuery[*PersonSchema], args ...any) weasel.SelectManyQuery[*PersonSchema] {
+					return q.Limit(1)
+				})
+				if j%2 == 0 {
+					people.DefaultScope(nil, "person.id ASC")
+				} else {
+					people.DefaultScope(nil, "")
+				}
+				found, err := people.Scoped(scope).OrderBy("first_name DESC").All().Exec()
+				if err != nil {
+					errs <- err
+				} else if len(found) != 1 || found[0].FirstName != "John" {
+					errs <- fmt.Errorf("unexpected people %v", found)
+				}
+				if _, err := people.FromGroup("FromUS").Scoped(scope).All().Exec(); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		s.assert.Nil(err)
+	}
+	people.DefaultScope(nil, "")
+	s.assert.Equal("person.id ASC", people.GetOrder())
+	found, err := people.All().Exec()
+	s.assert.Nil(err)
+	s.assert.Equal("John", found[0].FirstName)
+}
+
 func TestWeasel(t *testing.T) {
 	suite.Run(t, new(WeaselTestSuite))
 }